@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+// trustRootResource is the plural resource name of the policy-controller
+// TrustRoot CRD, which is cluster-scoped.
+const trustRootResource = "trustroots"
+
+// applyTrustRoot creates the rendered TrustRoot out in the cluster of the
+// kubeconfig at kubeconfigPath, or of the default kubeconfig when it is
+// empty, or updates the TrustRoot of the same name. kubeContext overrides
+// the kubeconfig's current context unless it is empty. It returns the name
+// of the TrustRoot and whether it was created.
+func applyTrustRoot(ctx context.Context, kubeconfigPath, kubeContext string, out []byte) (string, bool, error) {
+	data, err := yaml.YAMLToJSON(out)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to convert TrustRoot to JSON: %w", err)
+	}
+	trustRoot := &unstructured.Unstructured{}
+	if err := trustRoot.UnmarshalJSON(data); err != nil {
+		return "", false, fmt.Errorf("failed to decode TrustRoot: %w", err)
+	}
+	if trustRoot.GetName() == "" {
+		return "", false, fmt.Errorf("TrustRoot has no metadata.name")
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfigPath
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext}).ClientConfig()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return "", false, err
+	}
+	resource := client.Resource(trustRoot.GroupVersionKind().GroupVersion().WithResource(trustRootResource))
+
+	existing, err := resource.Get(ctx, trustRoot.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := resource.Create(ctx, trustRoot, metav1.CreateOptions{}); err != nil {
+			return "", false, err
+		}
+		return trustRoot.GetName(), true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	trustRoot.SetResourceVersion(existing.GetResourceVersion())
+	if _, err := resource.Update(ctx, trustRoot, metav1.UpdateOptions{}); err != nil {
+		return "", false, err
+	}
+	return trustRoot.GetName(), false, nil
+}
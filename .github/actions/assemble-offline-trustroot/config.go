@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variables flags are read from.
+const envPrefix = "AUTOTRUSTROOT_"
+
+// envVarName returns the environment variable the flag name is read from,
+// e.g. AUTOTRUSTROOT_TRUSTED_ROOT_PATH for --trusted-root-path.
+func envVarName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnvironment sets the flags of fs that were not given on the command
+// line from their environment variables, so flags given on the command line
+// override the environment. It runs before applyConfigFile, so the
+// environment in turn overrides the config file.
+func applyEnvironment(fs *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envVarName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = inputError("invalid value %q for %s: %w", value, envVarName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// applyConfigFile sets the flags of fs that were not already set, on the
+// command line or from the environment, from the YAML or JSON config file at
+// path. The config file in turn overrides the defaults.
+//
+// The config file is a mapping from flag names to values. List values set
+// repeatable flags once per element, and mapping values set flags taking
+// key=value pairs, such as uri-map, once per pair.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return inputError("failed to read config file: %w", err)
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return validationError("failed to parse config file %s: %w", path, err)
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return validationError("config file %s: unknown option %q", path, name)
+		}
+		if explicit[name] {
+			continue
+		}
+		values, err := configValues(config[name])
+		if err != nil {
+			return validationError("config file %s: option %q: %w", path, name, err)
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return validationError("config file %s: option %q: %w", path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues returns the flag values a config file value stands for.
+func configValues(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		var values []string
+		for _, element := range value {
+			elementValues, err := configValues(element)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elementValues...)
+		}
+		return values, nil
+	case map[interface{}]interface{}:
+		// Mappings with non-string keys, such as uri-map indices.
+		converted := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			converted[fmt.Sprint(key)] = element
+		}
+		return configValues(converted)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var values []string
+		for _, key := range keys {
+			values = append(values, fmt.Sprintf("%s=%v", key, value[key]))
+		}
+		return values, nil
+	default:
+		return []string{fmt.Sprint(value)}, nil
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// diffOp is one line of a line diff: kept (' '), removed ('-') or added
+// ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns a unified diff turning a into b, with a and b named
+// aName and bName in its header, or "" when they are equal.
+func unifiedDiff(aName, bName string, a, b []byte) string {
+	ops := diffLines(splitLines(string(a)), splitLines(string(b)))
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change and extend its hunk while changes are close
+		// enough for their context to overlap.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				if i-last > 2*diffContext {
+					break
+				}
+				last = i
+			}
+		}
+		hunkStart := max(first-diffContext, start)
+		hunkEnd := min(last+diffContext+1, len(ops))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+		}
+		aLine, bLine := lineNumbers(ops[:hunkStart])
+		aCount, bCount := lineNumbers(ops[hunkStart:hunkEnd])
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+		for _, op := range ops[hunkStart:hunkEnd] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+// splitLines splits text into lines without their line endings.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns the shortest edit script turning a into b, computed from
+// their longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// lineNumbers counts the lines of a and b covered by ops.
+func lineNumbers(ops []diffOp) (a, b int) {
+	for _, op := range ops {
+		if op.kind != '+' {
+			a++
+		}
+		if op.kind != '-' {
+			b++
+		}
+	}
+	return a, b
+}
+
+// hunkRange formats the range of a hunk header for count lines following
+// the first skipped lines.
+func hunkRange(skipped, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", skipped)
+	}
+	return fmt.Sprintf("%d,%d", skipped+1, count)
+}
@@ -0,0 +1,153 @@
+// Package logging is the leveled logger shared by the assembler and its CLI.
+// It logs to stderr, as text through the standard log package or as one JSON
+// object per line.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// levels maps the accepted level names to their levels.
+var levels = map[string]Level{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+func (l Level) String() string {
+	for name, level := range levels {
+		if level == l {
+			return strings.ToUpper(name)
+		}
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// minLevel is the lowest level that is logged.
+var minLevel = LevelInfo
+
+// SetLevel sets the lowest level that is logged from its name: debug, info,
+// warn or error.
+func SetLevel(name string) error {
+	level, ok := levels[name]
+	if !ok {
+		return fmt.Errorf("unknown log level %q, expected one of: debug, info, warn, error", name)
+	}
+	minLevel = level
+	return nil
+}
+
+// slogLevels maps levels to their log/slog equivalents.
+var slogLevels = map[Level]slog.Level{
+	LevelDebug: slog.LevelDebug,
+	LevelInfo:  slog.LevelInfo,
+	LevelWarn:  slog.LevelWarn,
+	LevelError: slog.LevelError,
+}
+
+// jsonLogger logs one JSON object per line when --log-format=json, and is nil
+// for the default text format.
+var jsonLogger *slog.Logger
+
+// SetFormat selects the log format from its name: text or json.
+func SetFormat(name string) error {
+	switch name {
+	case "text":
+		jsonLogger = nil
+	case "json":
+		// Levels are filtered by logf, so the handler logs everything.
+		jsonLogger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	default:
+		return fmt.Errorf("unknown log format %q, expected one of: text, json", name)
+	}
+	return nil
+}
+
+// logf logs a message at level. attrs are only emitted by the JSON format;
+// the text format relies on the message itself naming what it is about.
+func logf(level Level, attrs []slog.Attr, format string, args ...interface{}) {
+	if level < minLevel {
+		return
+	}
+	if jsonLogger != nil {
+		jsonLogger.LogAttrs(context.Background(), slogLevels[level], fmt.Sprintf(format, args...), attrs...)
+		return
+	}
+	log.Printf(level.String()+" "+format, args...)
+}
+
+func Debugf(format string, args ...interface{}) { logf(LevelDebug, nil, format, args...) }
+func Infof(format string, args ...interface{})  { logf(LevelInfo, nil, format, args...) }
+func Warnf(format string, args ...interface{})  { logf(LevelWarn, nil, format, args...) }
+func Errorf(format string, args ...interface{}) { logf(LevelError, nil, format, args...) }
+
+// EntryLogger logs messages about one entry of a trusted root list, adding
+// its authority and index as fields in the JSON format.
+type EntryLogger []slog.Attr
+
+// Entry returns the logger for the entry at index of the authority list.
+func Entry(authority string, index int) EntryLogger {
+	return EntryLogger{slog.String("authority", authority), slog.Int("index", index)}
+}
+
+func (l EntryLogger) Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, l, format, args...)
+}
+
+func (l EntryLogger) Infof(format string, args ...interface{}) {
+	logf(LevelInfo, l, format, args...)
+}
+
+func (l EntryLogger) Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, l, format, args...)
+}
+
+// Buffer holds the messages of an EntryLogger until Flush logs them, so work
+// done concurrently is logged in a deterministic order.
+type Buffer struct {
+	logger   EntryLogger
+	messages []bufferedMessage
+}
+
+type bufferedMessage struct {
+	level  Level
+	format string
+	args   []interface{}
+}
+
+// NewBuffer returns an empty Buffer for logger.
+func NewBuffer(logger EntryLogger) *Buffer {
+	return &Buffer{logger: logger}
+}
+
+func (b *Buffer) Debugf(format string, args ...interface{}) { b.add(LevelDebug, format, args) }
+func (b *Buffer) Infof(format string, args ...interface{})  { b.add(LevelInfo, format, args) }
+func (b *Buffer) Warnf(format string, args ...interface{})  { b.add(LevelWarn, format, args) }
+
+func (b *Buffer) add(level Level, format string, args []interface{}) {
+	b.messages = append(b.messages, bufferedMessage{level: level, format: format, args: args})
+}
+
+// Flush logs and discards the buffered messages.
+func (b *Buffer) Flush() {
+	for _, message := range b.messages {
+		logf(message.level, b.logger, message.format, message.args...)
+	}
+	b.messages = nil
+}
@@ -1,7 +1,835 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/falcorocks/AutoTrustRoot/logging"
+	"github.com/falcorocks/AutoTrustRoot/trustroot"
+	"github.com/sigstore/sigstore-go/pkg/tuf"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	debugf = logging.Debugf
+	infof  = logging.Infof
+	warnf  = logging.Warnf
+	errorf = logging.Errorf
+)
+
+const defaultTrustedRootPath = "~/.sigstore/root/targets/trusted_root.json"
+
+// stringList is a flag.Value collecting comma-separated values across
+// repeated uses of a flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// fileMode is a flag.Value holding file permission bits given in octal,
+// e.g. 0600.
+type fileMode os.FileMode
+
+func (m *fileMode) String() string {
+	return fmt.Sprintf("%04o", uint32(*m))
+}
+
+func (m *fileMode) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid octal file mode %q", value)
+	}
+	if mode&^uint64(os.ModePerm) != 0 {
+		return fmt.Errorf("file mode %q has bits other than permissions", value)
+	}
+	*m = fileMode(mode)
+	return nil
+}
+
+// Exit codes returned for the categories of errors run can fail with.
+const (
+	exitInput      = 2
+	exitValidation = 3
+	exitOutput     = 4
+	exitDrift      = 5
+	exitCanceled   = 6
+	exitEmpty      = 7
+	exitStrict     = 8
+	exitPartial    = 9
+)
+
+// Values accepted by --mode.
+const (
+	modeGenerate = "generate"
+	modeValidate = "validate"
+)
+
+// exitError is an error that maps to a specific process exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// inputError reports a problem with flags or with reading the template or
+// trusted roots.
+func inputError(format string, args ...interface{}) error {
+	return &exitError{code: exitInput, err: fmt.Errorf(format, args...)}
+}
+
+// validationError reports input that could be read but failed to parse or
+// validate.
+func validationError(format string, args ...interface{}) error {
+	return &exitError{code: exitValidation, err: fmt.Errorf(format, args...)}
+}
+
+// outputError reports a failure to render or write the TrustRoot.
+func outputError(format string, args ...interface{}) error {
+	return &exitError{code: exitOutput, err: fmt.Errorf(format, args...)}
+}
 
 func main() {
-	fmt.Println("Hello, World!")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx)
+	stop()
+	if err != nil {
+		errorf("%v", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the process exit code for an error returned by run.
+func exitCode(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	var assembleErr *trustroot.Error
+	if errors.As(err, &assembleErr) {
+		switch assembleErr.Kind {
+		case trustroot.KindInput:
+			return exitInput
+		case trustroot.KindValidation:
+			return exitValidation
+		case trustroot.KindOutput:
+			return exitOutput
+		case trustroot.KindStrict:
+			return exitStrict
+		}
+	}
+	return 1
+}
+
+func run(ctx context.Context) (err error) {
+	var trustedRootPaths stringList
+	flag.Var(&trustedRootPaths, "trusted-root-path", "Path or http(s) URL of a Sigstore trusted_root.json file, or - for stdin. May be repeated or comma-separated to merge several trusted roots (default "+defaultTrustedRootPath+" unless --tuf-mirror is set)")
+	templateFilePath := flag.String("template-filepath", trustroot.DefaultTemplatePath, "Path to the TrustRoot template file, optionally followed by comma-separated list=path pairs naming a template each entry of that spec.sigstoreKeys list is merged over, e.g. certificateAuthorities=ca.yaml")
+	sigstoreKeysPath := append(trustroot.SigstoreKeysPath(nil), trustroot.DefaultSigstoreKeysPath...)
+	flag.Var(&sigstoreKeysPath, "sigstorekeys-path", "Dotted path of the sigstoreKeys mapping in the template")
+	createMissingPath := flag.Bool("create-missing-path", false, "Create the mappings along --sigstorekeys-path that the template is missing")
+	outputFilePath := flag.String("output-trustroot-filepath", "/tmp/trustroot.yaml", "Path to write the assembled TrustRoot to")
+	force := flag.Bool("force", false, "Overwrite the output file if it already exists")
+	allowDefaultTemplate := flag.Bool("allow-default-template", false, "Use the embedded default TrustRoot template when the template file does not exist")
+	createOutputDir := flag.Bool("create-output-dir", false, "Create the directory of the output file if it does not exist")
+	organization := flag.String("organization", "", "Subject organization for authorities whose certificate has none")
+	commonName := flag.String("commonName", "", "Subject common name for authorities whose certificate has none")
+	subjectEmail := flag.String("subject-email", "", "Email to set in the subject of each authority, overriding the one in its certificate's Subject Alternative Name")
+	uri := flag.String("uri", "", "URI to set on each authority. When unset, a URI found in the authority's certificate is used")
+	caURI := flag.String("ca-uri", "", "URI to set on each certificate authority instead of --uri")
+	tsaURI := flag.String("tsa-uri", "", "URI to set on each timestamp authority instead of --uri")
+	var templateVars trustroot.TemplateVars
+	flag.Var(&templateVars, "template-var", "key=value pair substituted for ${key} in the templates before they are parsed. May be repeated")
+	strictVars := flag.Bool("strict-vars", false, "Fail if a ${...} placeholder in the templates has no --template-var value")
+	var uris trustroot.URIMap
+	flag.Var(&uris, "uri-map", "Comma-separated index=uri or commonName=uri pairs setting the URI of matching authorities instead of --uri. May be repeated")
+	tufMirror := flag.String("tuf-mirror", "", "URL of a TUF repository to fetch and verify trusted_root.json from, e.g. "+tuf.DefaultMirror)
+	var caPEMPaths, tsaPEMPaths stringList
+	flag.Var(&caPEMPaths, "ca-pem", "Path of a PEM file whose certificates, leaf first, form the chain of an additional certificate authority. May be repeated or comma-separated")
+	flag.Var(&tsaPEMPaths, "tsa-pem", "Path of a PEM file whose certificates, leaf first, form the chain of an additional timestamp authority. May be repeated or comma-separated")
+	tufRoot := flag.String("tuf-root", "", "Path to the TUF root.json to verify --tuf-mirror against (default the embedded Sigstore public-good root)")
+	timeout := flag.Duration("timeout", 0, "Abort the whole run after this duration, 0 for no limit")
+	retries := flag.Int("retries", trustroot.DefaultRetries, "How often to retry fetching a trusted root over the network after a network error or 5xx response")
+	retryBackoff := flag.Duration("retry-backoff", trustroot.DefaultRetryBackoff, "Delay before the first retry, doubled for each further one")
+	httpTimeout := flag.Duration("http-timeout", 30*time.Second, "Timeout for fetching the trusted root over HTTP")
+	annotateFingerprints := flag.Bool("annotate-fingerprints", false, "Add a comment with the SHA-256 fingerprints of its certificates above every authority")
+	splitRoot := flag.Bool("split-root", false, "Write the self-signed root certificate of each authority's chain to trustRoot instead of certChain")
+	deriveCAValidFor := flag.Bool("derive-ca-valid-for", false, "Set validFor of certificate authorities whose trusted root entry has none from the validity of their root certificate")
+	verifyLogID := flag.Bool("verify-log-id", false, "Warn when the logId of a transparency log is not the SHA-256 of its public key")
+	strict := flag.Bool("strict", false, "Fail on any malformed entry or certificate that would otherwise be skipped with a warning")
+	concurrency := flag.Int("concurrency", runtime.GOMAXPROCS(0), "How many authorities to parse and verify at once")
+	failOnExpired := flag.Bool("fail-on-expired", false, "Fail instead of warning when a certificate is expired or not yet valid")
+	failOnInvalidChain := flag.Bool("fail-on-invalid-chain", false, "Fail instead of warning when an authority's certificates do not form a valid chain")
+	failOnEmptyChain := flag.Bool("fail-on-empty-chain", false, "Fail instead of skipping an authority when none of its certificates could be converted")
+	failOnWeakCrypto := flag.Bool("fail-on-weak-crypto", false, "Fail instead of warning when a certificate is signed with SHA-1 or holds an RSA key under 2048 bits")
+	certificateBlockType := flag.String("certificate-block-type", trustroot.BlockTypeCertificate, "PEM block type of certificates in certChain: \""+trustroot.BlockTypeCertificate+"\" or \""+trustroot.BlockTypeTrustedCertificate+"\"")
+	certChainEncoding := flag.String("certchain-encoding", trustroot.CertChainBase64, "Encoding of certChain: base64, or pem for the PEM chain as a literal block")
+	chainOrder := flag.String("chain-order", trustroot.ChainOrderAsIs, "Order of the certificates in certChain: as-is keeps the trusted root's order, leaf-first or root-first order them by issuer")
+	var includeAuthorities, excludeAuthorities trustroot.AuthoritySelector
+	flag.Var(&includeAuthorities, "include-authority", "Only include certificate and timestamp authorities matching these comma-separated indices or subject substrings. May be repeated")
+	flag.Var(&excludeAuthorities, "exclude-authority", "Exclude certificate and timestamp authorities matching these comma-separated indices or subject substrings; takes precedence over --include-authority. May be repeated")
+	var allowedIssuers trustroot.IssuerAllowlist
+	flag.Var(&allowedIssuers, "allowed-issuer", "Only write certificate and timestamp authorities whose root certificate's issuer has one of these comma-separated common names or organizations. May be repeated")
+	unapprovedIssuerAction := flag.String("unapproved-issuer-action", trustroot.IssuerActionSkip, "What to do with an authority whose issuer is not allowed by --allowed-issuer: skip or fail")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text, or json for one JSON object per line")
+	outputFormat := flag.String("output-format", "yaml", "Output format of the TrustRoot: yaml or json, or "+trustroot.OutputPEMBundle+" to write only the self-signed root certificates of the certificate authorities as a PEM bundle")
+	yamlIndent := flag.Int("yaml-indent", trustroot.DefaultYAMLIndent, "Number of spaces, from 2 to 9, to indent YAML output by")
+	metadataName := flag.String("metadata-name", "", "Override metadata.name of the TrustRoot")
+	metadataNamespace := flag.String("metadata-namespace", "", "Set metadata.namespace of the TrustRoot")
+	normalize := flag.Bool("normalize", true, "Write the output with LF line endings, without trailing whitespace and with a single trailing newline")
+	documentSeparator := flag.Bool("document-separator", false, "Render one TrustRoot per trusted root as a multi-document YAML stream instead of merging them")
+	inPlace := flag.Bool("in-place", false, "Refresh spec.sigstoreKeys of the existing output file instead of starting from the template")
+	mergeMode := flag.String("merge-mode", trustroot.MergeReplace, "How entries are written: replace regenerates the lists from the template, upsert keeps the existing output's entries, updating those matching an authority's subject or a log's baseURL and appending the rest")
+	mode := flag.String("mode", modeGenerate, "What to do with the assembled TrustRoot: generate writes it, validate compares it against the existing output file and exits non-zero on drift")
+	summaryFilePath := flag.String("summary-file", "", "Write a JSON summary of the authorities processed to this path, unless nothing is written because of --dry-run, --diff or --mode=validate")
+	outputMode := fileMode(0644)
+	flag.Var(&outputMode, "output-mode", "Octal permissions of the output file and the checksum, summary and signature files written next to it")
+	writeChecksum := flag.Bool("write-checksum", false, "Write the SHA-256 of the output to <output>.sha256 in sha256sum format")
+	check := flag.Bool("check", false, "Only check that every authority of the trusted roots decodes, forms a valid chain and is currently valid, print the result per authority to stdout and fail unless all pass")
+	printCerts := flag.Bool("print-certs", false, "Print the certificates of every authority as a table to stdout instead of assembling a TrustRoot")
+	maxChainLength := flag.Int("max-chain-length", trustroot.DefaultMaxChainLength, "Skip authorities whose certChain lists more certificates than this, or fail with --strict")
+	extractCertChain := flag.Int("extract-certchain", -1, "Print only the base64 certChain of the authority at this index of the trusted root to stdout instead of assembling a TrustRoot")
+	authorityType := flag.String("authority-type", "certificateAuthorities", "Authority list --extract-certchain reads from: certificateAuthorities or timestampAuthorities")
+	splitOutput := flag.Bool("split-output", false, "Write certificate authorities, along with the transparency logs, and timestamp authorities to two TrustRoots named after the output file with -certificate-authorities and -timestamp-authorities appended")
+	validateSchema := flag.Bool("validate-schema", false, "Check the assembled TrustRoot against the fields the policy-controller TrustRoot CRD requires and fail on any violation")
+	allowPartial := flag.Bool("allow-partial", false, "Write the TrustRoot and exit 0 even when some authorities could only be written in part or not at all")
+	allowEmpty := flag.Bool("allow-empty", false, "Write the TrustRoot even when no certificate or timestamp authority was written to it")
+	push := flag.Bool("push", false, "Push the written TrustRoot as an OCI artifact to --registry-ref, using the credentials of the Docker config")
+	registryRef := flag.String("registry-ref", "", "Tagged registry reference to push the TrustRoot to with --push, e.g. registry.example.com/trustroot:v1")
+	apply := flag.Bool("apply", false, "Create or update the written TrustRoot in the Kubernetes cluster of the current kubeconfig context")
+	kubeconfig := flag.String("kubeconfig", "", "Path to the kubeconfig to --apply with (default $KUBECONFIG or ~/.kube/config)")
+	kubeContext := flag.String("kube-context", "", "kubeconfig context to --apply to instead of the current one")
+	signOutput := flag.Bool("sign", false, "Sign the written TrustRoot and write the signature as a Sigstore bundle to <output>"+signatureBundleSuffix)
+	signingKey := flag.String("signing-key", "", "Path to the unencrypted PEM private key to sign with --sign")
+	fulcioURL := flag.String("fulcio-url", "", "URL of the Fulcio to get a signing certificate from with --sign instead of using --signing-key, e.g. https://fulcio.sigstore.dev")
+	identityToken := flag.String("identity-token", "", "OIDC identity token to present to --fulcio-url")
+	rekorURL := flag.String("rekor-url", "", "URL of the Rekor to log the signature made with --sign to, e.g. https://rekor.sigstore.dev. Unset for no transparency log entry")
+	showDiff := flag.Bool("diff", false, "Print a unified diff of the existing output file against the assembled TrustRoot to stdout instead of writing it")
+	watch := flag.Bool("watch", false, "Keep running and regenerate the TrustRoot whenever a local trusted root or template changes, until interrupted")
+	dryRun := flag.Bool("dry-run", false, "Print the assembled TrustRoot to stdout instead of writing the output file")
+	configFilePath := flag.String("config", "", "YAML or JSON file setting flags by name; flags given on the command line take precedence")
+	flag.Parse()
+
+	if err := applyEnvironment(flag.CommandLine); err != nil {
+		return err
+	}
+	if *configFilePath != "" {
+		path, err := expandPath(*configFilePath)
+		if err != nil {
+			return inputError("failed to expand path %q: %w", *configFilePath, err)
+		}
+		if err := applyConfigFile(flag.CommandLine, path); err != nil {
+			return err
+		}
+	}
+
+	if err := logging.SetLevel(*logLevel); err != nil {
+		return inputError("%w", err)
+	}
+	if err := logging.SetFormat(*logFormat); err != nil {
+		return inputError("%w", err)
+	}
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	// Whatever step failed once the run timed out or was interrupted, the
+	// cancellation is what should be reported.
+	defer func() {
+		if err == nil || ctx.Err() == nil {
+			return
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = &exitError{code: exitCanceled, err: fmt.Errorf("timed out after %s: %w", *timeout, err)}
+		} else {
+			err = &exitError{code: exitCanceled, err: fmt.Errorf("interrupted: %w", err)}
+		}
+	}()
+
+	if *retries < 0 {
+		return inputError("--retries must not be negative")
+	}
+	// 0 would otherwise fall back to the library default and skip nothing.
+	if *maxChainLength <= 0 {
+		return inputError("--max-chain-length must be positive, got %d", *maxChainLength)
+	}
+
+	if *mode != modeGenerate && *mode != modeValidate {
+		return inputError("unknown mode %q, expected one of: %s, %s", *mode, modeGenerate, modeValidate)
+	}
+	if *mode == modeValidate && *documentSeparator {
+		return inputError("--mode=%s does not support --document-separator", modeValidate)
+	}
+
+	if *mergeMode == trustroot.MergeUpsert && (*mode != modeGenerate || *documentSeparator) {
+		return inputError("--merge-mode=%s requires --mode=%s and does not support --document-separator", trustroot.MergeUpsert, modeGenerate)
+	}
+	if *inPlace && (*mode != modeGenerate || *documentSeparator) {
+		return inputError("--in-place requires --mode=%s and does not support --document-separator", modeGenerate)
+	}
+	if *push && (*mode != modeGenerate || *splitOutput || *showDiff || *printCerts) {
+		return inputError("--push requires --mode=%s and does not support --split-output, --diff or --print-certs", modeGenerate)
+	}
+	if *apply && (*mode != modeGenerate || *splitOutput || *showDiff || *printCerts || *documentSeparator) {
+		return inputError("--apply requires --mode=%s and does not support --split-output, --diff, --print-certs or --document-separator", modeGenerate)
+	}
+	if !*apply && (*kubeconfig != "" || *kubeContext != "") {
+		return inputError("--kubeconfig and --kube-context require --apply")
+	}
+	if *push != (*registryRef != "") {
+		return inputError("--push and --registry-ref must be given together")
+	}
+	signing := signingOptions{keyPath: *signingKey, fulcioURL: *fulcioURL, identityToken: *identityToken, rekorURL: *rekorURL}
+	if *signOutput {
+		if *mode != modeGenerate || *splitOutput || *showDiff || *printCerts {
+			return inputError("--sign requires --mode=%s and does not support --split-output, --diff or --print-certs", modeGenerate)
+		}
+		if err := validateSigningOptions(signing); err != nil {
+			return inputError("%w", err)
+		}
+	}
+	if *showDiff && (*mode != modeGenerate || *splitOutput) {
+		return inputError("--diff requires --mode=%s and does not support --split-output", modeGenerate)
+	}
+	if *splitOutput && (*mode != modeGenerate || *documentSeparator || *inPlace || *mergeMode == trustroot.MergeUpsert) {
+		return inputError("--split-output requires --mode=%s and does not support --document-separator, --in-place or --merge-mode=%s", modeGenerate, trustroot.MergeUpsert)
+	}
+
+	if *documentSeparator && *outputFormat != "yaml" {
+		return inputError("--document-separator requires the yaml output format")
+	}
+	if *outputFormat == trustroot.OutputPEMBundle && (*mode != modeGenerate || *splitOutput || *inPlace || *mergeMode == trustroot.MergeUpsert || *apply) {
+		return inputError("--output-format=%s requires --mode=%s and does not support --split-output, --in-place, --merge-mode=%s or --apply", trustroot.OutputPEMBundle, modeGenerate, trustroot.MergeUpsert)
+	}
+	extracting := *extractCertChain >= 0
+	if *check && (*mode != modeGenerate || *splitOutput || *showDiff || *printCerts || *push || *signOutput || *apply || extracting) {
+		return inputError("--check requires --mode=%s and does not support --split-output, --diff, --print-certs, --push, --sign, --apply or --extract-certchain", modeGenerate)
+	}
+	if extracting && (*mode != modeGenerate || *splitOutput || *showDiff || *printCerts || *push || *signOutput || *apply) {
+		return inputError("--extract-certchain requires --mode=%s and does not support --split-output, --diff, --print-certs, --push, --sign or --apply", modeGenerate)
+	}
+
+	// The trusted root is fetched from the TUF mirror instead of the default
+	// path unless paths are given explicitly too.
+	if len(trustedRootPaths) == 0 && *tufMirror == "" {
+		trustedRootPaths = stringList{defaultTrustedRootPath}
+	}
+
+	baseTemplatePath, entryTemplatePaths, err := trustroot.ParseTemplatePaths(*templateFilePath)
+	if err != nil {
+		return inputError("invalid --template-filepath: %w", err)
+	}
+	*templateFilePath = baseTemplatePath
+
+	paths := []*string{templateFilePath, outputFilePath, tufRoot, summaryFilePath, kubeconfig}
+	for i := range trustedRootPaths {
+		paths = append(paths, &trustedRootPaths[i])
+	}
+	for i := range caPEMPaths {
+		paths = append(paths, &caPEMPaths[i])
+	}
+	for i := range tsaPEMPaths {
+		paths = append(paths, &tsaPEMPaths[i])
+	}
+	for _, path := range paths {
+		expanded, err := expandPath(*path)
+		if err != nil {
+			return inputError("failed to expand path %q: %w", *path, err)
+		}
+		*path = expanded
+	}
+	for section, path := range entryTemplatePaths {
+		expanded, err := expandPath(path)
+		if err != nil {
+			return inputError("failed to expand path %q: %w", path, err)
+		}
+		entryTemplatePaths[section] = expanded
+	}
+
+	if *allowDefaultTemplate {
+		if _, err := os.Stat(*templateFilePath); os.IsNotExist(err) {
+			infof("Template %s does not exist, using the embedded default template", *templateFilePath)
+			*templateFilePath = embeddedTemplatePath
+		}
+	}
+
+	var watchedPaths []string
+	if *watch {
+		if watchedPaths, err = watchedInputs(trustedRootPaths, *templateFilePath, entryTemplatePaths, caPEMPaths, tsaPEMPaths); err != nil {
+			return inputError("%w", err)
+		}
+	}
+
+	generate := func(ctx context.Context) error {
+		sourcePath := *templateFilePath
+		editExisting := false
+		if *inPlace {
+			sourcePath = *outputFilePath
+			editExisting = true
+		} else if *mergeMode == trustroot.MergeUpsert {
+			// Upserting starts from the existing output, falling back to the
+			// template when there is none yet.
+			if _, err := os.Stat(*outputFilePath); err == nil {
+				sourcePath = *outputFilePath
+				editExisting = true
+			} else if !os.IsNotExist(err) {
+				return inputError("failed to stat output file: %w", err)
+			}
+		}
+		if !*dryRun && !*showDiff && !*printCerts && !extracting && !*check && *mode == modeGenerate {
+			if err := ensureOutputDir(*outputFilePath, *createOutputDir); err != nil {
+				return err
+			}
+			// An existing output is only replaced on request, unless it is the
+			// TrustRoot being edited.
+			if !*force && !editExisting {
+				outputPaths := []string{*outputFilePath}
+				if *splitOutput {
+					outputPaths = []string{splitOutputPath(*outputFilePath, "certificate-authorities"), splitOutputPath(*outputFilePath, "timestamp-authorities")}
+				}
+				for _, path := range outputPaths {
+					if err := refuseOverwrite(path); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		cfg := trustroot.Config{
+			FS:                     cliFS,
+			TrustedRootPaths:       trustedRootPaths,
+			TUFMirror:              *tufMirror,
+			TUFRoot:                *tufRoot,
+			CAPEMPaths:             caPEMPaths,
+			TSAPEMPaths:            tsaPEMPaths,
+			SigstoreKeysPath:       sigstoreKeysPath,
+			CreateMissingPath:      *createMissingPath,
+			EntryTemplatePaths:     entryTemplatePaths,
+			TemplateVars:           templateVars,
+			StrictVars:             *strictVars,
+			ClearSigstoreKeys:      *inPlace && *mergeMode == trustroot.MergeReplace,
+			MergeMode:              *mergeMode,
+			Organization:           *organization,
+			CommonName:             *commonName,
+			SubjectEmail:           *subjectEmail,
+			URI:                    *uri,
+			CAURI:                  *caURI,
+			TSAURI:                 *tsaURI,
+			URIMap:                 uris,
+			IncludeAuthorities:     includeAuthorities,
+			ExcludeAuthorities:     excludeAuthorities,
+			AllowedIssuers:         allowedIssuers,
+			UnapprovedIssuerAction: *unapprovedIssuerAction,
+			DeriveCAValidFor:       *deriveCAValidFor,
+			SplitRoot:              *splitRoot,
+			VerifyLogIDs:           *verifyLogID,
+			AnnotateFingerprints:   *annotateFingerprints,
+			FailOnExpired:          *failOnExpired,
+			FailOnInvalidChain:     *failOnInvalidChain,
+			FailOnEmptyChain:       *failOnEmptyChain,
+			FailOnWeakCrypto:       *failOnWeakCrypto,
+			CertificateBlockType:   *certificateBlockType,
+			CertChainEncoding:      *certChainEncoding,
+			ChainOrder:             *chainOrder,
+			MetadataName:           *metadataName,
+			MetadataNamespace:      *metadataNamespace,
+			OutputFormat:           *outputFormat,
+			YAMLIndent:             *yamlIndent,
+			DocumentSeparator:      *documentSeparator,
+			HTTPTimeout:            *httpTimeout,
+			Retries:                *retries,
+			RetryBackoff:           *retryBackoff,
+			Strict:                 *strict,
+			Concurrency:            *concurrency,
+			MaxChainLength:         *maxChainLength,
+		}
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		if *check {
+			checks, err := trustroot.Check(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			passed, err := printChecks(os.Stdout, checks)
+			if err != nil {
+				return outputError("failed to print check results: %w", err)
+			}
+			if passed < len(checks) {
+				return validationError("%d of %d authorities failed the check", len(checks)-passed, len(checks))
+			}
+			infof("All %d authorities passed the check", len(checks))
+			return nil
+		}
+		if extracting {
+			certChain, err := trustroot.ExtractCertChain(ctx, cfg, *authorityType, *extractCertChain)
+			if err != nil {
+				return err
+			}
+			fmt.Println(certChain)
+			return nil
+		}
+		if *printCerts {
+			rows, err := trustroot.ListCertificates(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			if err := printCertificates(os.Stdout, rows); err != nil {
+				return outputError("failed to print certificates: %w", err)
+			}
+			return nil
+		}
+		// Fail before any trusted root is fetched if the TrustRoot cannot be
+		// filled in. The template is then loaded once, filled in in memory and
+		// written once at the end.
+		if err := trustroot.ValidateTemplate(cliFS, sourcePath, sigstoreKeysPath, *createMissingPath, templateVars, *strictVars); err != nil {
+			return err
+		}
+		cfg.TemplatePath = sourcePath
+
+		result, err := trustroot.Build(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		// Like the output, the summary is not written by runs that only
+		// print or compare.
+		if *summaryFilePath != "" && !*dryRun && !*showDiff && *mode == modeGenerate {
+			summary, err := result.Summary.Marshal()
+			if err != nil {
+				return outputError("failed to marshal summary: %w", err)
+			}
+			if err := cliFS.WriteFile(*summaryFilePath, summary, os.FileMode(outputMode)); err != nil {
+				return outputError("failed to write summary file: %w", err)
+			}
+			infof("Wrote summary of %d authorities to %s", result.Summary.Count, *summaryFilePath)
+		}
+
+		if *validateSchema {
+			violations := 0
+			for i, document := range result.Documents {
+				for _, violation := range trustroot.ValidateSchema(document) {
+					if len(result.Documents) > 1 {
+						violation = fmt.Sprintf("document %d: %s", i, violation)
+					}
+					warnf("Schema violation: %s", violation)
+					violations++
+				}
+			}
+			if violations > 0 {
+				return validationError("the TrustRoot does not match the TrustRoot CRD: %d violation(s)", violations)
+			}
+			debugf("The TrustRoot matches the TrustRoot CRD")
+		}
+
+		if *mode == modeGenerate && result.Summary.Count == 0 {
+			if !*allowEmpty {
+				return &exitError{code: exitEmpty, err: errors.New("no certificate or timestamp authority was written, pass --allow-empty to write the TrustRoot anyway")}
+			}
+			warnf("No certificate or timestamp authority was written")
+		}
+		if *mode == modeGenerate {
+			if err := reportOutcomes(result.Summary, *allowPartial); err != nil {
+				return err
+			}
+		}
+
+		if *mode == modeValidate {
+			existing, err := trustroot.LoadYAML(cliFS, *outputFilePath)
+			if err != nil {
+				return inputError("failed to load existing TrustRoot: %w", err)
+			}
+			diffs, err := trustroot.DiffSigstoreKeys(result.Root, existing, sigstoreKeysPath)
+			if err != nil {
+				return validationError("failed to compare TrustRoots: %w", err)
+			}
+			for _, diff := range diffs {
+				warnf("Drift in %s: %s", *outputFilePath, diff)
+			}
+			if len(diffs) > 0 {
+				return &exitError{code: exitDrift, err: fmt.Errorf("%s has drifted from the trusted root: %d difference(s)", *outputFilePath, len(diffs))}
+			}
+			infof("%s matches the trusted root", *outputFilePath)
+			return nil
+		}
+
+		if *splitOutput {
+			certificateAuthorities, timestampAuthorities, err := trustroot.SplitByAuthority(result.Root, sigstoreKeysPath)
+			if err != nil {
+				return validationError("failed to split TrustRoot: %w", err)
+			}
+			// Render both before writing either, so a failure leaves no file
+			// half-updated.
+			outputs := []splitFile{
+				{path: splitOutputPath(*outputFilePath, "certificate-authorities"), document: certificateAuthorities},
+				{path: splitOutputPath(*outputFilePath, "timestamp-authorities"), document: timestampAuthorities},
+			}
+			for i := range outputs {
+				if outputs[i].data, err = result.RenderDocument(outputs[i].document); err != nil {
+					return err
+				}
+				if *normalize {
+					outputs[i].data = normalizeOutput(outputs[i].data)
+				}
+			}
+			var paths []string
+			size := 0
+			for _, output := range outputs {
+				data := output.data
+				// Both documents go to stdout in a dry run, so keep them
+				// apart as a YAML stream.
+				if *dryRun && *outputFormat == "yaml" {
+					data = append([]byte("---\n"), data...)
+				}
+				if err := writeOutput(cliFS, output.path, data, os.FileMode(outputMode), *dryRun, *writeChecksum); err != nil {
+					return err
+				}
+				paths = append(paths, output.path)
+				size += len(output.data)
+			}
+			if !*dryRun {
+				infof("Wrote %s", strings.Join(paths, " and "))
+			}
+			logTotals(result.Summary, size)
+			return nil
+		}
+
+		out, err := result.Render()
+		if err != nil {
+			return err
+		}
+		if *normalize && *outputFormat != trustroot.OutputPEMBundle {
+			out = normalizeOutput(out)
+		}
+		if *showDiff {
+			existing, err := os.ReadFile(*outputFilePath)
+			if err != nil && !os.IsNotExist(err) {
+				return inputError("failed to read existing TrustRoot: %w", err)
+			}
+			diff := unifiedDiff(*outputFilePath, *outputFilePath+" (assembled)", existing, out)
+			if diff == "" {
+				infof("%s is up to date", *outputFilePath)
+				return nil
+			}
+			if _, err := io.WriteString(os.Stdout, diff); err != nil {
+				return outputError("failed to write diff to stdout: %w", err)
+			}
+			return nil
+		}
+		if err := writeOutput(cliFS, *outputFilePath, out, os.FileMode(outputMode), *dryRun, *writeChecksum); err != nil {
+			return err
+		}
+		logTotals(result.Summary, len(out))
+
+		if *signOutput {
+			if *dryRun {
+				infof("Not signing %s in a dry run", *outputFilePath)
+			} else {
+				bundle, signer, err := signTrustRoot(ctx, signing, out)
+				if err != nil {
+					return outputError("failed to sign TrustRoot: %w", err)
+				}
+				bundlePath := *outputFilePath + signatureBundleSuffix
+				if err := cliFS.WriteFile(bundlePath, bundle, os.FileMode(outputMode)); err != nil {
+					return outputError("failed to write signature bundle: %w", err)
+				}
+				infof("Signed %s with %s", *outputFilePath, signer)
+				fmt.Println(bundlePath)
+			}
+		}
+
+		if *apply {
+			if *dryRun {
+				infof("Not applying %s in a dry run", *outputFilePath)
+			} else {
+				name, created, err := applyTrustRoot(ctx, *kubeconfig, *kubeContext, out)
+				if err != nil {
+					return outputError("failed to apply TrustRoot: %w", err)
+				}
+				if created {
+					infof("Created TrustRoot %s in the cluster", name)
+				} else {
+					infof("Updated TrustRoot %s in the cluster", name)
+				}
+			}
+		}
+
+		if *push {
+			if *dryRun {
+				infof("Not pushing to %s in a dry run", *registryRef)
+				return nil
+			}
+			digest, err := pushTrustRoot(ctx, *registryRef, *outputFilePath, *outputFormat, out)
+			if err != nil {
+				return outputError("failed to push TrustRoot to %s: %w", *registryRef, err)
+			}
+			infof("Pushed %s to %s", *outputFilePath, *registryRef)
+			fmt.Println(digest)
+		}
+		return nil
+	}
+	if !*watch {
+		return generate(ctx)
+	}
+	return watchInputs(ctx, watchedPaths, func(ctx context.Context) error {
+		err := generate(ctx)
+		if err == nil {
+			// Later runs replace the output this one wrote.
+			*force = true
+		}
+		return err
+	})
+}
+
+// splitFile is one of the files written with --split-output.
+type splitFile struct {
+	path     string
+	document *yaml.Node
+	data     []byte
+}
+
+// splitOutputPath returns the path of the --split-output file with the given
+// suffix: path with "-"+suffix inserted before its extension.
+func splitOutputPath(path, suffix string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + suffix + ext
+}
+
+// logTotals logs a one-line summary of the assembled TrustRoot, size bytes
+// long once rendered.
+func logTotals(summary *trustroot.Summary, size int) {
+	infof("Assembled %d certificate authorities and %d timestamp authorities into %d bytes, skipping %d certificates",
+		summary.CertificateAuthorities, summary.TimestampAuthorities, size, summary.SkippedCertificates)
+}
+
+// reportOutcomes logs how many authorities were written completely, written
+// partially and failed, and each that was not written completely. Unless
+// allowPartial is set, it fails if any was not.
+func reportOutcomes(summary *trustroot.Summary, allowPartial bool) error {
+	complete, partial, failed := summary.Outcomes()
+	for _, authority := range summary.Authorities {
+		switch authority.Status {
+		case trustroot.StatusPartial:
+			warnf("Partially wrote %s at index %d of %s: %d of %d certificates", authority.Type, authority.Index, authority.Source, authority.Certificates, authority.Listed)
+		case trustroot.StatusFailed:
+			warnf("Failed to write %s at index %d of %s", authority.Type, authority.Index, authority.Source)
+		}
+	}
+	if partial == 0 && failed == 0 {
+		debugf("Wrote all %d authorities completely", complete)
+		return nil
+	}
+	infof("Wrote %d authorities completely and %d partially, %d failed", complete, partial, failed)
+	if !allowPartial {
+		return &exitError{code: exitPartial, err: fmt.Errorf("%d authorities were written partially and %d failed, pass --allow-partial to write the TrustRoot anyway", partial, failed)}
+	}
+	return nil
+}
+
+// writeOutput writes the rendered TrustRoot out to path in fsys with
+// permissions perm, or to stdout when dryRun is set, along with its checksum
+// file when writeChecksum is set.
+func writeOutput(fsys trustroot.WriterFS, path string, out []byte, perm os.FileMode, dryRun, writeChecksum bool) error {
+	if dryRun {
+		if _, err := os.Stdout.Write(out); err != nil {
+			return outputError("failed to write TrustRoot to stdout: %w", err)
+		}
+		return nil
+	}
+
+	if err := fsys.WriteFile(path, out, perm); err != nil {
+		return outputError("failed to write output file: %w", err)
+	}
+
+	if writeChecksum {
+		digest, err := writeChecksumFile(fsys, path, out, perm)
+		if err != nil {
+			return outputError("failed to write checksum file: %w", err)
+		}
+		infof("Wrote %s with SHA-256 %s", path, digest)
+	}
+	return nil
+}
+
+// expandPath resolves a leading "~" or "~/" to the current user's home
+// directory. Any other path is returned unchanged.
+func expandPath(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
+
+// ensureOutputDir checks that the directory the output file at path is
+// written to exists, creating it when create is set.
+func ensureOutputDir(path string, create bool) error {
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	switch {
+	case err == nil && !info.IsDir():
+		return outputError("output directory %s is not a directory", dir)
+	case err == nil:
+		return nil
+	case !os.IsNotExist(err):
+		return outputError("failed to check output directory: %w", err)
+	case !create:
+		return outputError("output directory %s does not exist, create it or pass --create-output-dir", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return outputError("failed to create output directory: %w", err)
+	}
+	infof("Created output directory %s", dir)
+	return nil
+}
+
+// refuseOverwrite returns an error when a file exists at path.
+func refuseOverwrite(path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		return outputError("output file %s already exists, pass --force to overwrite it", path)
+	} else if !os.IsNotExist(err) {
+		return outputError("failed to check output file: %w", err)
+	}
+	return nil
+}
+
+// writeChecksumFile writes the SHA-256 of data, the bytes written to path,
+// to path+".sha256" in fsys in the "<hex>  <filename>" format read by
+// sha256sum -c, with permissions perm, and returns the hex digest.
+func writeChecksumFile(fsys trustroot.WriterFS, path string, data []byte, perm os.FileMode) (string, error) {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	line := fmt.Sprintf("%s  %s\n", digest, filepath.Base(path))
+	return digest, fsys.WriteFile(path+".sha256", []byte(line), perm)
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/falcorocks/AutoTrustRoot/trustroot"
+)
+
+// memFS is an in-memory trustroot.WriterFS.
+type memFS struct {
+	fstest.MapFS
+}
+
+func (f memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f.MapFS[name] = &fstest.MapFile{Data: append([]byte(nil), data...), Mode: perm}
+	return nil
+}
+
+func TestWriteOutput(t *testing.T) {
+	fsys := memFS{fstest.MapFS{}}
+	out := []byte("apiVersion: policy.sigstore.dev/v1alpha1\nkind: TrustRoot\n")
+	if err := writeOutput(fsys, "out/trustroot.yaml", out, 0640, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(fsys, "out/trustroot.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(out) {
+		t.Errorf("output = %q, want %q", got, out)
+	}
+	if mode := fsys.MapFS["out/trustroot.yaml"].Mode; mode != 0640 {
+		t.Errorf("output mode = %v, want %v", mode, fs.FileMode(0640))
+	}
+
+	sum := sha256.Sum256(out)
+	checksum, err := fs.ReadFile(fsys, "out/trustroot.yaml.sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hex.EncodeToString(sum[:]) + "  trustroot.yaml\n"; string(checksum) != want {
+		t.Errorf("checksum file = %q, want %q", checksum, want)
+	}
+}
+
+func TestWriteOutputDryRun(t *testing.T) {
+	fsys := memFS{fstest.MapFS{}}
+	if err := writeOutput(fsys, "trustroot.yaml", []byte{}, 0644, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(fsys.MapFS) != 0 {
+		t.Errorf("dry run wrote %d files, want none", len(fsys.MapFS))
+	}
+}
+
+func TestReportOutcomes(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		statuses     []string
+		allowPartial bool
+		wantCode     int
+	}{
+		{name: "complete", statuses: []string{trustroot.StatusComplete}},
+		{name: "skipped", statuses: []string{trustroot.StatusComplete, trustroot.StatusSkipped}},
+		{name: "duplicate", statuses: []string{trustroot.StatusComplete, trustroot.StatusDuplicate}},
+		{name: "partial", statuses: []string{trustroot.StatusComplete, trustroot.StatusPartial}, wantCode: exitPartial},
+		{name: "failed", statuses: []string{trustroot.StatusComplete, trustroot.StatusFailed}, wantCode: exitPartial},
+		{name: "failed with allowPartial", statuses: []string{trustroot.StatusFailed}, allowPartial: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			summary := &trustroot.Summary{}
+			for i, status := range test.statuses {
+				summary.Authorities = append(summary.Authorities, &trustroot.AuthoritySummary{Type: "certificateAuthorities", Index: i, Status: status})
+			}
+			err := reportOutcomes(summary, test.allowPartial)
+			var exitErr *exitError
+			switch {
+			case test.wantCode == 0 && err != nil:
+				t.Errorf("reportOutcomes() = %v, want nil", err)
+			case test.wantCode != 0 && (!errors.As(err, &exitErr) || exitErr.code != test.wantCode):
+				t.Errorf("reportOutcomes() = %v, want exit code %d", err, test.wantCode)
+			}
+		})
+	}
+}
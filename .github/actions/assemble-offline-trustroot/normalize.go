@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// normalizeOutput returns the rendered TrustRoot out with LF line endings,
+// no trailing whitespace and a single trailing newline. YAML and JSON only
+// change in comments and formatting by this; should it alter any value,
+// such as a literal block scalar ending in spaces, out is returned
+// unchanged.
+func normalizeOutput(out []byte) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(out), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	normalized := []byte(strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n")
+
+	if !sameDocuments(out, normalized) {
+		warnf("Not normalizing the output: it would change the TrustRoot")
+		return out
+	}
+	return normalized
+}
+
+// sameDocuments reports whether the YAML or JSON streams a and b hold the
+// same documents.
+func sameDocuments(a, b []byte) bool {
+	documentsA, err := decodeDocuments(a)
+	if err != nil {
+		return false
+	}
+	documentsB, err := decodeDocuments(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(documentsA, documentsB)
+}
+
+// decodeDocuments decodes every document of the YAML or JSON stream data.
+func decodeDocuments(data []byte) ([]interface{}, error) {
+	var documents []interface{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var document interface{}
+		if err := decoder.Decode(&document); err != nil {
+			if errors.Is(err, io.EOF) {
+				return documents, nil
+			}
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/falcorocks/AutoTrustRoot/trustroot"
+)
+
+// printCertificates writes rows to w as a table.
+func printCertificates(w io.Writer, rows []trustroot.CertificateRow) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "AUTHORITY\tINDEX\tCERT\tSUBJECT\tISSUER\tSERIAL\tSHA-256\tNOT BEFORE\tNOT AFTER")
+	for _, row := range rows {
+		fingerprint := sha256.Sum256(row.Cert.Raw)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%x\t%x\t%s\t%s\n",
+			row.Authority,
+			row.Index,
+			row.CertIndex,
+			row.Cert.Subject,
+			row.Cert.Issuer,
+			row.Cert.SerialNumber,
+			fingerprint,
+			row.Cert.NotBefore.UTC().Format(time.RFC3339),
+			row.Cert.NotAfter.UTC().Format(time.RFC3339),
+		)
+	}
+	return tw.Flush()
+}
+
+// printChecks writes checks to w as a table, one row per problem of each
+// authority that failed, and returns the number of authorities that passed.
+func printChecks(w io.Writer, checks []trustroot.AuthorityCheck) (int, error) {
+	passed := 0
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESULT\tAUTHORITY\tINDEX\tSOURCE\tSUBJECT\tPROBLEM")
+	for _, check := range checks {
+		if check.Passed() {
+			passed++
+			fmt.Fprintf(tw, "PASS\t%s\t%d\t%s\t%s\t\n", check.Authority, check.Index, check.Source, check.Subject)
+			continue
+		}
+		for _, problem := range check.Problems {
+			fmt.Fprintf(tw, "FAIL\t%s\t%d\t%s\t%s\t%v\n", check.Authority, check.Index, check.Source, check.Subject, problem)
+		}
+	}
+	return passed, tw.Flush()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/falcorocks/AutoTrustRoot/trustroot"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/memory"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// trustRootArtifactType is the artifact type of TrustRoots pushed with
+// --push.
+const trustRootArtifactType = "application/vnd.dev.sigstore.policy-controller.trustroot"
+
+// layerMediaTypes maps each --output-format to the media type of the layer
+// holding the TrustRoot.
+var layerMediaTypes = map[string]string{
+	"yaml":                    "application/yaml",
+	"json":                    "application/json",
+	trustroot.OutputPEMBundle: "application/x-pem-file",
+}
+
+// pushTrustRoot pushes the rendered TrustRoot out as a single-layer OCI
+// artifact to the tagged reference ref, authenticating with the credentials
+// of the Docker config. The layer is titled with the base name of fileName.
+// It returns the digest of the pushed manifest.
+func pushTrustRoot(ctx context.Context, ref, fileName, outputFormat string, out []byte) (string, error) {
+	repo, err := remote.NewRepository(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid registry reference %q: %w", ref, err)
+	}
+	if err := repo.Reference.ValidateReferenceAsTag(); err != nil {
+		return "", fmt.Errorf("registry reference %q must end in a tag: %w", ref, err)
+	}
+	tag := repo.Reference.Reference
+
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to load Docker credentials: %w", err)
+	}
+	repo.Client = &auth.Client{
+		Client:     retry.DefaultClient,
+		Cache:      auth.NewCache(),
+		Credential: credentials.Credential(store),
+	}
+
+	staging := memory.New()
+	layer, err := oras.PushBytes(ctx, staging, layerMediaTypes[outputFormat], out)
+	if err != nil {
+		return "", err
+	}
+	layer.Annotations = map[string]string{ocispec.AnnotationTitle: filepath.Base(fileName)}
+	manifest, err := oras.PackManifest(ctx, staging, oras.PackManifestVersion1_1, trustRootArtifactType, oras.PackManifestOptions{
+		Layers: []ocispec.Descriptor{layer},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to pack manifest: %w", err)
+	}
+	if err := staging.Tag(ctx, manifest, tag); err != nil {
+		return "", err
+	}
+
+	pushed, err := oras.Copy(ctx, staging, tag, repo, tag, oras.DefaultCopyOptions)
+	if err != nil {
+		return "", err
+	}
+	return pushed.Digest.String(), nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	protobundle "github.com/sigstore/protobuf-specs/gen/pb-go/bundle/v1"
+	protocommon "github.com/sigstore/protobuf-specs/gen/pb-go/common/v1"
+	"github.com/sigstore/sigstore-go/pkg/sign"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// signatureBundleSuffix is appended to the output file name to name the
+// Sigstore bundle written with --sign.
+const signatureBundleSuffix = ".sigstore.json"
+
+// signingOptions configures how --sign signs the TrustRoot: with the private
+// key at keyPath, or keyless with a certificate Fulcio at fulcioURL issues
+// for identityToken. The signature is logged to the Rekor at rekorURL unless
+// it is empty.
+type signingOptions struct {
+	keyPath       string
+	fulcioURL     string
+	identityToken string
+	rekorURL      string
+}
+
+// signTrustRoot signs out, the rendered TrustRoot, and returns the Sigstore
+// bundle holding the signature along with a description of the key or
+// certificate it was made with.
+func signTrustRoot(ctx context.Context, opts signingOptions, out []byte) ([]byte, string, error) {
+	bundleOpts := sign.BundleOptions{Context: ctx}
+	var keypair sign.Keypair
+	if opts.keyPath != "" {
+		fileKeypair, err := loadKeypair(opts.keyPath)
+		if err != nil {
+			return nil, "", err
+		}
+		keypair = fileKeypair
+	} else {
+		ephemeralKeypair, err := sign.NewEphemeralKeypair(nil)
+		if err != nil {
+			return nil, "", err
+		}
+		keypair = ephemeralKeypair
+		bundleOpts.CertificateProvider = sign.NewFulcio(&sign.FulcioOptions{BaseURL: opts.fulcioURL})
+		bundleOpts.CertificateProviderOptions = &sign.CertificateProviderOptions{IDToken: opts.identityToken}
+	}
+	if opts.rekorURL != "" {
+		bundleOpts.TransparencyLogs = append(bundleOpts.TransparencyLogs, sign.NewRekor(&sign.RekorOptions{BaseURL: opts.rekorURL}))
+	}
+
+	bundle, err := sign.Bundle(&sign.PlainData{Data: out}, keypair, bundleOpts)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := protojson.Marshal(bundle)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal bundle: %w", err)
+	}
+	return data, describeSigner(bundle, keypair), nil
+}
+
+// describeSigner returns the subject and identities of the certificate in
+// bundle or, for a bundle signed with a key, the key's hint.
+func describeSigner(bundle *protobundle.Bundle, keypair sign.Keypair) string {
+	if raw := bundle.GetVerificationMaterial().GetCertificate().GetRawBytes(); raw != nil {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return "an unparseable certificate"
+		}
+		description := fmt.Sprintf("certificate %s issued by %s", cert.Subject, cert.Issuer)
+		for _, email := range cert.EmailAddresses {
+			description += ", email " + email
+		}
+		for _, uri := range cert.URIs {
+			description += ", URI " + uri.String()
+		}
+		return description
+	}
+	return fmt.Sprintf("%s key %s", keypair.GetKeyAlgorithm(), keypair.GetHint())
+}
+
+// fileKeypair is a sign.Keypair backed by a private key read from a file.
+type fileKeypair struct {
+	signer     crypto.Signer
+	algDetails signature.AlgorithmDetails
+	hint       []byte
+}
+
+// loadKeypair reads the unencrypted PEM encoded ECDSA, RSA or Ed25519 private
+// key at path. Its hint is the base64 SHA-256 of the public key, as for
+// sign.EphemeralKeypair.
+func loadKeypair(path string) (*fileKeypair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cryptoutils.UnmarshalPEMToPrivateKey(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	publicKeyDetails, err := signature.GetDefaultPublicKeyDetails(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("unsupported signing key: %w", err)
+	}
+	algDetails, err := signature.GetAlgorithmDetails(publicKeyDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(publicKey)
+	return &fileKeypair{
+		signer:     signer,
+		algDetails: algDetails,
+		hint:       []byte(base64.StdEncoding.EncodeToString(hash[:])),
+	}, nil
+}
+
+func (k *fileKeypair) GetHashAlgorithm() protocommon.HashAlgorithm {
+	return k.algDetails.GetProtoHashType()
+}
+
+func (k *fileKeypair) GetSigningAlgorithm() protocommon.PublicKeyDetails {
+	return k.algDetails.GetSignatureAlgorithm()
+}
+
+func (k *fileKeypair) GetHint() []byte {
+	return k.hint
+}
+
+func (k *fileKeypair) GetKeyAlgorithm() string {
+	switch k.algDetails.GetKeyType() {
+	case signature.ECDSA:
+		return "ECDSA"
+	case signature.RSA:
+		return "RSA"
+	case signature.ED25519:
+		return "ED25519"
+	}
+	return ""
+}
+
+func (k *fileKeypair) GetPublicKey() crypto.PublicKey {
+	return k.signer.Public()
+}
+
+func (k *fileKeypair) GetPublicKeyPem() (string, error) {
+	pemBytes, err := cryptoutils.MarshalPublicKeyToPEM(k.signer.Public())
+	if err != nil {
+		return "", err
+	}
+	return string(pemBytes), nil
+}
+
+// SignData returns the signature of data and what was signed: its digest,
+// or data itself for pure Ed25519.
+func (k *fileKeypair) SignData(_ context.Context, data []byte) ([]byte, []byte, error) {
+	hash := k.algDetails.GetHashType()
+	signed := data
+	if hash != crypto.Hash(0) {
+		hasher := hash.New()
+		hasher.Write(data)
+		signed = hasher.Sum(nil)
+	}
+	sig, err := k.signer.Sign(rand.Reader, signed, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sig, signed, nil
+}
+
+// validateSigningOptions checks that opts name exactly one way of signing.
+func validateSigningOptions(opts signingOptions) error {
+	switch {
+	case opts.keyPath != "" && opts.fulcioURL != "":
+		return errors.New("--signing-key and --fulcio-url are mutually exclusive")
+	case opts.keyPath == "" && opts.fulcioURL == "":
+		return errors.New("--sign requires --signing-key or --fulcio-url")
+	case opts.fulcioURL != "" && opts.identityToken == "":
+		return errors.New("--fulcio-url requires --identity-token")
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+
+	"github.com/falcorocks/AutoTrustRoot/trustroot"
+)
+
+// embeddedTemplates holds the default TrustRoot template, used with
+// --allow-default-template when the template file does not exist.
+//
+//go:embed trustroot.template.yaml
+var embeddedTemplates embed.FS
+
+// embeddedTemplatePath is the path the embedded default template is read
+// from through cliFS.
+const embeddedTemplatePath = "embedded:" + trustroot.DefaultTemplatePath
+
+// cliFS is the filesystem of the CLI: the OS filesystem, plus the embedded
+// default template at embeddedTemplatePath.
+var cliFS trustroot.WriterFS = templateFS{trustroot.OSFS}
+
+type templateFS struct {
+	trustroot.WriterFS
+}
+
+func (f templateFS) Open(name string) (fs.File, error) {
+	if name == embeddedTemplatePath {
+		return embeddedTemplates.Open(trustroot.DefaultTemplatePath)
+	}
+	return f.WriterFS.Open(name)
+}
@@ -0,0 +1,1012 @@
+// Package trustroot assembles a policy-controller TrustRoot from Sigstore
+// trusted_root.json files. It backs the assemble-offline-trustroot CLI and
+// can be used directly by Go programs.
+package trustroot
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/falcorocks/AutoTrustRoot/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultMaxChainLength is the most certificates an authority's chain may
+// list when Config.MaxChainLength is zero.
+const DefaultMaxChainLength = 10
+
+// Defaults for retrying network fetches of trusted roots.
+const (
+	DefaultRetries      = 3
+	DefaultRetryBackoff = time.Second
+)
+
+// Config configures an assembly run. The zero value of every field but
+// TemplatePath and one of TrustedRootPaths, TUFMirror or the PEM paths is
+// usable.
+type Config struct {
+	// TrustedRootPaths are paths or http(s) URLs of trusted_root.json files,
+	// or "-" for stdin, merged in order.
+	TrustedRootPaths []string
+	// TUFMirror is the URL of a TUF repository to fetch and verify
+	// trusted_root.json from before TrustedRootPaths. TUFRoot is the
+	// root.json to verify it against, by default the embedded Sigstore
+	// public-good root.
+	TUFMirror string
+	TUFRoot   string
+	// CAPEMPaths and TSAPEMPaths are PEM files each holding the chain of a
+	// certificate or timestamp authority, added after the trusted roots as
+	// if they were one more.
+	CAPEMPaths  []string
+	TSAPEMPaths []string
+
+	// FS is the filesystem the template, entry templates, TUF root and
+	// trusted roots given as local paths are read from, by default OSFS.
+	FS fs.FS
+
+	// TemplatePath is the TrustRoot document the authorities and logs are
+	// written into: a template, or an existing TrustRoot.
+	TemplatePath string
+	// SigstoreKeysPath is where the sigstoreKeys mapping is in TemplatePath,
+	// by default DefaultSigstoreKeysPath. With CreateMissingPath, missing
+	// mappings along it are created.
+	SigstoreKeysPath  SigstoreKeysPath
+	CreateMissingPath bool
+	// EntryTemplatePaths maps spec.sigstoreKeys list names to templates each
+	// entry written to that list is merged over.
+	EntryTemplatePaths map[string]string
+	// TemplateVars are substituted into the ${KEY} placeholders of
+	// TemplatePath and the entry templates before they are parsed. With
+	// StrictVars, a placeholder without a value is an error.
+	TemplateVars TemplateVars
+	StrictVars   bool
+	// ClearSigstoreKeys empties the lists of TemplatePath before writing to
+	// them.
+	ClearSigstoreKeys bool
+	// MergeMode is MergeReplace (the default) or MergeUpsert. Upserting
+	// keeps the order of the existing entries, appending the new ones.
+	MergeMode string
+
+	// Organization and CommonName are the subject of authorities whose
+	// certificate has none.
+	Organization string
+	CommonName   string
+	// SubjectEmail overrides the email found in each authority's certificate.
+	SubjectEmail string
+	// URI is set on each authority; when empty, the authority's uri in the
+	// trusted root is used, then a URI found in its certificate. CAURI and
+	// TSAURI take precedence over URI for certificate and timestamp
+	// authorities respectively, and URIMap over all of them.
+	URI    string
+	CAURI  string
+	TSAURI string
+	URIMap URIMap
+
+	// IncludeAuthorities and ExcludeAuthorities select the certificate and
+	// timestamp authorities written.
+	IncludeAuthorities AuthoritySelector
+	ExcludeAuthorities AuthoritySelector
+	// AllowedIssuers restricts the certificate and timestamp authorities
+	// written to those whose root certificate is issued by one of them.
+	// UnapprovedIssuerAction is IssuerActionSkip (the default) to skip any
+	// other authority, or IssuerActionFail to fail.
+	AllowedIssuers         IssuerAllowlist
+	UnapprovedIssuerAction string
+
+	// FailOnExpired, FailOnInvalidChain, FailOnEmptyChain and
+	// FailOnWeakCrypto turn the corresponding warnings into errors.
+	FailOnExpired      bool
+	FailOnInvalidChain bool
+	FailOnEmptyChain   bool
+	FailOnWeakCrypto   bool
+
+	// CertificateBlockType is the PEM block type of certificates, by default
+	// BlockTypeCertificate.
+	CertificateBlockType string
+	// CertChainEncoding is CertChainBase64 (the default) or CertChainPEM.
+	CertChainEncoding string
+	// ChainOrder is ChainOrderAsIs (the default), ChainOrderLeafFirst or
+	// ChainOrderRootFirst.
+	ChainOrder string
+
+	// VerifyLogIDs checks that the logId of every transparency log is the
+	// SHA-256 of its public key, warning when it is not.
+	VerifyLogIDs bool
+
+	// SplitRoot writes the self-signed root of each authority's chain to
+	// trustRoot instead of certChain. A chain made of the root alone keeps
+	// it in certChain. A chain with more than one self-signed certificate is
+	// an error.
+	SplitRoot bool
+
+	// DeriveCAValidFor sets the validFor of certificate authorities whose
+	// trusted root entry declares none to the validity of their root
+	// certificate.
+	DeriveCAValidFor bool
+
+	// AnnotateFingerprints adds a comment with the SHA-256 fingerprints of
+	// its certificates above every authority. Comments are only rendered in
+	// YAML.
+	AnnotateFingerprints bool
+
+	// MetadataName and MetadataNamespace override the metadata of the
+	// TrustRoot when set.
+	MetadataName      string
+	MetadataNamespace string
+	// OutputFormat is "yaml" (the default), "json" or OutputPEMBundle.
+	OutputFormat string
+	// YAMLIndent is the number of spaces, from 2 to 9, YAML output is
+	// indented by, DefaultYAMLIndent if zero.
+	YAMLIndent int
+	// DocumentSeparator renders one TrustRoot per trusted root as a
+	// multi-document YAML stream instead of merging them.
+	DocumentSeparator bool
+
+	// HTTPTimeout bounds each HTTP fetch, 0 for no limit. Failed network
+	// fetches are retried Retries times, after RetryBackoff doubled for
+	// each further retry.
+	HTTPTimeout  time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+
+	// Strict fails on any malformed entry or certificate that would
+	// otherwise be logged as a warning and skipped, with a KindStrict error.
+	Strict bool
+
+	// Concurrency is the number of authorities prepared at once, by default
+	// GOMAXPROCS.
+	Concurrency int
+	// MaxChainLength is the most certificates an authority's chain may list,
+	// by default DefaultMaxChainLength. Longer chains are skipped with a
+	// warning before any certificate is decoded.
+	MaxChainLength int
+}
+
+// defaultURI returns the URI set on the certificate or timestamp authorities,
+// as named by authority, that URIMap does not map.
+func (c Config) defaultURI(authority string) string {
+	if authority == "timestampAuthorities" && c.TSAURI != "" {
+		return c.TSAURI
+	}
+	if authority == "certificateAuthorities" && c.CAURI != "" {
+		return c.CAURI
+	}
+	return c.URI
+}
+
+// withDefaults returns c with empty enumerated fields set to their default.
+func (c Config) withDefaults() Config {
+	if c.MergeMode == "" {
+		c.MergeMode = MergeReplace
+	}
+	if c.CertificateBlockType == "" {
+		c.CertificateBlockType = BlockTypeCertificate
+	}
+	if c.CertChainEncoding == "" {
+		c.CertChainEncoding = CertChainBase64
+	}
+	if c.ChainOrder == "" {
+		c.ChainOrder = ChainOrderAsIs
+	}
+	if c.UnapprovedIssuerAction == "" {
+		c.UnapprovedIssuerAction = IssuerActionSkip
+	}
+	if c.OutputFormat == "" {
+		c.OutputFormat = "yaml"
+	}
+	if c.YAMLIndent == 0 {
+		c.YAMLIndent = DefaultYAMLIndent
+	}
+	if c.FS == nil {
+		c.FS = OSFS
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = runtime.GOMAXPROCS(0)
+	}
+	if c.MaxChainLength == 0 {
+		c.MaxChainLength = DefaultMaxChainLength
+	}
+	return c
+}
+
+// Validate checks the enumerated and free-form values of c.
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	if c.Retries < 0 {
+		return inputError("retries must not be negative")
+	}
+	if c.Concurrency < 0 {
+		return inputError("concurrency must not be negative")
+	}
+	if c.MaxChainLength < 0 {
+		return inputError("maximum chain length must not be negative")
+	}
+	if c.YAMLIndent < 2 || c.YAMLIndent > maxYAMLIndent {
+		return inputError("YAML indent must be between 2 and %d, got %d", maxYAMLIndent, c.YAMLIndent)
+	}
+	if _, ok := outputFormats[c.OutputFormat]; !ok && c.OutputFormat != OutputPEMBundle {
+		return inputError("unknown output format %q, expected one of: yaml, json, %s", c.OutputFormat, OutputPEMBundle)
+	}
+	if c.CertificateBlockType != BlockTypeCertificate && c.CertificateBlockType != BlockTypeTrustedCertificate {
+		return inputError("unknown certificate block type %q, expected %q or %q", c.CertificateBlockType, BlockTypeCertificate, BlockTypeTrustedCertificate)
+	}
+	if c.CertChainEncoding != CertChainBase64 && c.CertChainEncoding != CertChainPEM {
+		return inputError("unknown certChain encoding %q, expected one of: %s, %s", c.CertChainEncoding, CertChainBase64, CertChainPEM)
+	}
+	if c.ChainOrder != ChainOrderAsIs && c.ChainOrder != ChainOrderLeafFirst && c.ChainOrder != ChainOrderRootFirst {
+		return inputError("unknown chain order %q, expected one of: %s, %s, %s", c.ChainOrder, ChainOrderAsIs, ChainOrderLeafFirst, ChainOrderRootFirst)
+	}
+	if c.UnapprovedIssuerAction != IssuerActionSkip && c.UnapprovedIssuerAction != IssuerActionFail {
+		return inputError("unknown unapproved issuer action %q, expected one of: %s, %s", c.UnapprovedIssuerAction, IssuerActionSkip, IssuerActionFail)
+	}
+	if c.MergeMode != MergeReplace && c.MergeMode != MergeUpsert {
+		return inputError("unknown merge mode %q, expected one of: %s, %s", c.MergeMode, MergeReplace, MergeUpsert)
+	}
+	if c.MergeMode == MergeUpsert && c.DocumentSeparator {
+		return inputError("merge mode %s does not support a document separator", MergeUpsert)
+	}
+	if c.DocumentSeparator && c.OutputFormat != "yaml" {
+		return inputError("a document separator requires the yaml output format")
+	}
+	for field, value := range map[string]string{"organization": c.Organization, "commonName": c.CommonName} {
+		if sanitizeSubjectField(value) != value {
+			return inputError("invalid %s %q: must be valid UTF-8 without control characters", field, value)
+		}
+	}
+	for field, value := range map[string]string{"metadata name": c.MetadataName, "metadata namespace": c.MetadataNamespace} {
+		if value != "" && !isRFC1123Label(value) {
+			return inputError("invalid %s %q: must be a lowercase RFC 1123 label of at most 63 characters", field, value)
+		}
+	}
+	if len(c.TrustedRootPaths) == 0 && c.TUFMirror == "" && len(c.CAPEMPaths) == 0 && len(c.TSAPEMPaths) == 0 {
+		return inputError("no trusted root given")
+	}
+	return nil
+}
+
+// Result is an assembled TrustRoot.
+type Result struct {
+	// Documents holds one TrustRoot per trusted root with
+	// Config.DocumentSeparator, and the single merged TrustRoot otherwise.
+	Documents []*yaml.Node
+	// Root is the last of Documents.
+	Root *yaml.Node
+	// Summary describes the authorities processed.
+	Summary *Summary
+
+	outputFormat      string
+	yamlIndent        int
+	documentSeparator bool
+	// roots are the self-signed certificates of the certificate authorities
+	// written, rendered with OutputPEMBundle.
+	roots []*x509.Certificate
+}
+
+// Render marshals the TrustRoot in the configured output format.
+func (r *Result) Render() ([]byte, error) {
+	if r.outputFormat == OutputPEMBundle {
+		if len(r.roots) == 0 {
+			return nil, outputError("no certificate authority written has a self-signed root certificate")
+		}
+		out, err := marshalPEMBundle(r.roots)
+		if err != nil {
+			return nil, outputError("failed to encode PEM bundle: %w", err)
+		}
+		return out, nil
+	}
+	if !r.documentSeparator {
+		return r.RenderDocument(r.Root)
+	}
+	out, err := marshalYAMLStream(r.Documents, r.yamlIndent, true)
+	if err != nil {
+		return nil, outputError("failed to marshal TrustRoot: %w", err)
+	}
+	return out, nil
+}
+
+// RenderDocument marshals a single TrustRoot document, such as one returned
+// by SplitByAuthority, in the configured output format.
+func (r *Result) RenderDocument(root *yaml.Node) ([]byte, error) {
+	if r.outputFormat == OutputPEMBundle {
+		return nil, outputError("the %s output format renders no TrustRoot documents", OutputPEMBundle)
+	}
+	out, err := outputFormats[r.outputFormat](root, r.yamlIndent)
+	if err != nil {
+		return nil, outputError("failed to marshal TrustRoot: %w", err)
+	}
+	return out, nil
+}
+
+// Assemble builds the TrustRoot described by cfg and renders it.
+func Assemble(cfg Config) ([]byte, error) {
+	result, err := Build(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return result.Render()
+}
+
+// assembler holds the state of a Build.
+type assembler struct {
+	cfg            Config
+	fetcher        *rootFetcher
+	entryTemplates map[string]*yaml.Node
+	result         *Result
+
+	// Entries from every trusted root rendered into the same document are
+	// appended after those already written, so offsets tracks the next free
+	// index of each section and seen the chains or keys already written to
+	// it. With MergeUpsert, claimed tracks the existing entries already
+	// updated instead.
+	offsets map[string]int
+	seen    map[string]map[string]bool
+	claimed map[string]map[int]bool
+	// written maps the authority entries written to their summary, so
+	// those removed as duplicates once assembled can be accounted for.
+	written map[*yaml.Node]*AuthoritySummary
+}
+
+// Build reads the trusted roots of cfg and writes their authorities and
+// transparency logs into the TrustRoot at cfg.TemplatePath. The returned
+// documents are ready to render, with their sigstoreKeys lists sorted unless
+// cfg.MergeMode is MergeUpsert.
+func Build(ctx context.Context, cfg Config) (*Result, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	entryTemplates, err := loadEntryTemplates(cfg.FS, cfg.EntryTemplatePaths, cfg.TemplateVars, cfg.StrictVars)
+	if err != nil {
+		return nil, inputError("failed to load entry template: %w", err)
+	}
+
+	a := &assembler{
+		cfg:            cfg,
+		fetcher:        &rootFetcher{fsys: cfg.FS, client: &http.Client{Timeout: cfg.HTTPTimeout}, retries: cfg.Retries, retryBackoff: cfg.RetryBackoff},
+		entryTemplates: entryTemplates,
+		written:        map[*yaml.Node]*AuthoritySummary{},
+		result: &Result{
+			Summary:           &Summary{Authorities: []*AuthoritySummary{}},
+			outputFormat:      cfg.OutputFormat,
+			yamlIndent:        cfg.YAMLIndent,
+			documentSeparator: cfg.DocumentSeparator,
+		},
+	}
+
+	sources, err := a.readSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		a.result.Summary.Sources = append(a.result.Summary.Sources, source.name)
+	}
+
+	for i, source := range sources {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if i == 0 || cfg.DocumentSeparator {
+			if err := a.startDocument(); err != nil {
+				return nil, err
+			}
+		}
+
+		debugf("Processing trusted root %s", source.name)
+		canonicalizeTrustedRoot(source.trustedRoot, source.name)
+
+		for _, authority := range []string{"certificateAuthorities", "timestampAuthorities"} {
+			if err := a.addAuthorities(source, authority); err != nil {
+				return nil, err
+			}
+		}
+		for _, logType := range []string{"tlogs", "ctlogs"} {
+			if err := a.addTransparencyLogs(source, logType); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	documents := a.result.Documents
+	for i, document := range documents {
+		removed, err := removeDuplicateAuthorities(document, cfg.SigstoreKeysPath)
+		if err != nil {
+			return nil, validationError("failed to deduplicate TrustRoot: %w", err)
+		}
+		if len(removed) > 0 {
+			infof("Removed %d duplicate authorities from the TrustRoot", len(removed))
+		}
+		for _, entry := range removed {
+			if entrySummary, ok := a.written[entry]; ok {
+				a.result.Summary.markDuplicate(entrySummary)
+			} else {
+				a.result.Summary.DuplicatesRemoved++
+			}
+		}
+		// Upserting edits an existing TrustRoot, whose order is kept.
+		if cfg.MergeMode != MergeUpsert {
+			if err := sortSigstoreKeys(document, cfg.SigstoreKeysPath); err != nil {
+				return nil, validationError("failed to sort TrustRoot: %w", err)
+			}
+		}
+		if len(documents) > 1 {
+			suffixName(document, fmt.Sprintf("-%d", i))
+		}
+	}
+	return a.result, nil
+}
+
+// readSources reads the trusted roots of the TUF mirror, every trusted root
+// path and the PEM files, in that order.
+func (a *assembler) readSources(ctx context.Context) ([]trustedRootSource, error) {
+	var sources []trustedRootSource
+	if a.cfg.TUFMirror != "" {
+		trustedRoot, err := a.fetcher.fetchTUFTrustedRoot(ctx, a.cfg.TUFMirror, a.cfg.TUFRoot)
+		if err != nil {
+			return nil, err
+		}
+		mediaType, err := checkMediaType(trustedRoot)
+		if err != nil {
+			return nil, fmt.Errorf("trusted root from TUF mirror %s: %w", a.cfg.TUFMirror, err)
+		}
+		infof("Fetched trusted root from TUF mirror %s (%s)", a.cfg.TUFMirror, mediaType)
+		sources = append(sources, trustedRootSource{name: tufSourceName(a.cfg.TUFMirror), trustedRoot: trustedRoot})
+	}
+	for _, trustedRootPath := range a.cfg.TrustedRootPaths {
+		trustedRoot, err := a.fetcher.readTrustedRoot(ctx, trustedRootPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trusted root %s: %w", trustedRootPath, err)
+		}
+		mediaType, err := checkMediaType(trustedRoot)
+		if err != nil {
+			return nil, fmt.Errorf("trusted root %s: %w", trustedRootPath, err)
+		}
+		infof("Read trusted root %s (%s)", trustedRootPath, mediaType)
+		sources = append(sources, trustedRootSource{name: trustedRootPath, trustedRoot: trustedRoot})
+	}
+	if len(a.cfg.CAPEMPaths) > 0 || len(a.cfg.TSAPEMPaths) > 0 {
+		source, err := readPEMSource(a.cfg.FS, a.cfg.CAPEMPaths, a.cfg.TSAPEMPaths)
+		if err != nil {
+			return nil, inputError("failed to read PEM file %w", err)
+		}
+		infof("Read %d certificate and %d timestamp authorities from PEM files", len(a.cfg.CAPEMPaths), len(a.cfg.TSAPEMPaths))
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// startDocument loads a new TrustRoot document from the template and makes
+// it the one entries are written to.
+func (a *assembler) startDocument() error {
+	root, err := loadTemplate(a.cfg.FS, a.cfg.TemplatePath, a.cfg.TemplateVars, a.cfg.StrictVars)
+	if err != nil {
+		return inputError("failed to load TrustRoot template: %w", err)
+	}
+	a.result.Documents = append(a.result.Documents, root)
+	a.result.Root = root
+
+	if a.cfg.CreateMissingPath {
+		if _, err := a.cfg.SigstoreKeysPath.walk(root, true); err != nil {
+			return validationError("failed to create sigstoreKeys: %w", err)
+		}
+	}
+	if a.cfg.ClearSigstoreKeys {
+		if err := clearSigstoreKeys(root, a.cfg.SigstoreKeysPath); err != nil {
+			return validationError("failed to clear TrustRoot: %w", err)
+		}
+	}
+	if a.cfg.MetadataName != "" {
+		setMetadata(root, "name", a.cfg.MetadataName)
+	}
+	if a.cfg.MetadataNamespace != "" {
+		setMetadata(root, "namespace", a.cfg.MetadataNamespace)
+	}
+
+	a.offsets = map[string]int{}
+	a.seen = map[string]map[string]bool{}
+	a.claimed = map[string]map[int]bool{}
+	for _, section := range []string{"certificateAuthorities", "timestampAuthorities", "tlogs", "ctlogs"} {
+		a.seen[section] = map[string]bool{}
+		a.claimed[section] = map[int]bool{}
+	}
+	return nil
+}
+
+// forEach calls fn with every index below n on up to Config.Concurrency
+// goroutines, and returns once all calls have.
+func (a *assembler) forEach(n int, fn func(index int)) {
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < a.cfg.Concurrency && worker < n; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				fn(index)
+			}
+		}()
+	}
+	for index := 0; index < n; index++ {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+}
+
+// preparedAuthority is an authority entry of a trusted root with its
+// certificates parsed, deduplicated, ordered and verified.
+type preparedAuthority struct {
+	// log holds the messages logged while preparing, to be flushed in index
+	// order.
+	log *logging.Buffer
+	// data is the trusted root entry, nil when it is not an object or has no
+	// certificates.
+	data map[string]interface{}
+	// certs and certIndexes are the certificates of the chain, in certChain
+	// order, and their indices in the trusted root.
+	certs       []*x509.Certificate
+	certIndexes []int
+	// listed is the number of certificates the trusted root lists for the
+	// entry, and tooLong whether that is more than Config.MaxChainLength,
+	// in which case none of them is decoded.
+	listed  int
+	tooLong bool
+	// pemData is the PEM chain to write and fingerprints the SHA-256 of its
+	// certificates, in the same order.
+	pemData      []byte
+	fingerprints []string
+	// rootPEM is the self-signed root split off pemData with
+	// Config.SplitRoot, nil when the chain has none or is the root alone.
+	// splitErr is set when the chain has more than one.
+	rootPEM  []byte
+	splitErr error
+	// chainErr is the error verifying the chain, if any.
+	chainErr error
+	// warnings are the warnings logged while preparing, and warning the
+	// first of them, if any.
+	warnings []error
+	warning  error
+}
+
+// warnf logs a warning to the entry's buffer and records it if it is the
+// first.
+func (p *preparedAuthority) warnf(format string, args ...interface{}) {
+	p.log.Warnf(format, args...)
+	p.warnings = append(p.warnings, newWarning(format, args...))
+	if p.warning == nil {
+		p.warning = p.warnings[0]
+	}
+}
+
+// warnf logs a warning about an entry to log. With Config.Strict, it returns
+// the warning as an error instead.
+func (a *assembler) warnf(log logger, format string, args ...interface{}) error {
+	if a.cfg.Strict {
+		return strictError(newWarning(format, args...))
+	}
+	log.Warnf(format, args...)
+	return nil
+}
+
+// prepareAuthority parses the authority entry at index of the named list.
+// It is safe to call concurrently and logs only to the returned buffer.
+func (a *assembler) prepareAuthority(authority string, index, total int, authorityEntry interface{}) *preparedAuthority {
+	cfg := a.cfg
+	entryLog := logging.NewBuffer(logging.Entry(authority, index))
+	prepared := &preparedAuthority{log: entryLog}
+	entryLog.Debugf("[%d/%d] Processing %s at index %d", index+1, total, authority, index)
+
+	authorityData, ok := authorityEntry.(map[string]interface{})
+	if !ok {
+		prepared.warnf("Invalid %s entry at index %d", authority, index)
+		return prepared
+	}
+
+	// certChain is usually an object wrapping the certificates, but some
+	// trusted roots list them directly.
+	var certificates []interface{}
+	switch certChainData := authorityData["certChain"].(type) {
+	case map[string]interface{}:
+		certificates, ok = certChainData["certificates"].([]interface{})
+		if !ok {
+			prepared.warnf("No certificates found for %s at index %d", authority, index)
+			return prepared
+		}
+		entryLog.Debugf("Reading certChain of %s at index %d as an object", authority, index)
+	case []interface{}:
+		certificates = certChainData
+		entryLog.Debugf("Reading certChain of %s at index %d as a list", authority, index)
+	default:
+		prepared.warnf("No certChain found for %s at index %d", authority, index)
+		return prepared
+	}
+	prepared.listed = len(certificates)
+	if len(certificates) > cfg.MaxChainLength {
+		prepared.warnf("Skipping %s at index %d: %v: %d certificates, more than the maximum of %d", authority, index, ErrChainTooLong, len(certificates), cfg.MaxChainLength)
+		prepared.tooLong = true
+		return prepared
+	}
+	prepared.data = authorityData
+
+	var pemBlocks [][]byte
+	var certs []*x509.Certificate
+	var certIndexes []int
+	fingerprints := map[[sha256.Size]byte]int{}
+	for certIndex, certEntry := range certificates {
+		certData, ok := certEntry.(map[string]interface{})
+		if !ok {
+			prepared.warnf("Invalid certificate entry %d for %s at index %d", certIndex, authority, index)
+			continue
+		}
+
+		rawBytes, ok := certData["rawBytes"].(string)
+		if !ok {
+			prepared.warnf("No rawBytes found for certificate %d of %s at index %d", certIndex, authority, index)
+			continue
+		}
+
+		der, err := decodeRawBytes(rawBytes, entryLog)
+		if err != nil {
+			prepared.warnf("Certificate %d of %s at index %d: %v", certIndex, authority, index, err)
+			continue
+		}
+
+		if err := checkDER(der); err != nil {
+			prepared.warnf("Certificate %d of %s at index %d: %v", certIndex, authority, index, err)
+			continue
+		}
+
+		pemBytes, cert, err := convertToPEM(der, cfg.CertificateBlockType, entryLog)
+		if err != nil {
+			prepared.warnf("Certificate %d of %s at index %d: %v", certIndex, authority, index, err)
+			continue
+		}
+		fingerprint := sha256.Sum256(cert.Raw)
+		if first, ok := fingerprints[fingerprint]; ok {
+			entryLog.Debugf("Dropping certificate %d of %s at index %d: duplicate of certificate %d", certIndex, authority, index, first)
+			continue
+		}
+		fingerprints[fingerprint] = certIndex
+		certs = append(certs, cert)
+		certIndexes = append(certIndexes, certIndex)
+		pemBlocks = append(pemBlocks, pemBytes)
+	}
+
+	reversed := false
+	if cfg.ChainOrder != ChainOrderAsIs && len(certs) > 1 {
+		if order, err := leafFirstOrder(certs); err != nil {
+			prepared.warnf("Keeping the certChain order of %s at index %d: %v", authority, index, err)
+		} else {
+			certs, certIndexes, pemBlocks = permute(certs, order), permute(certIndexes, order), permute(pemBlocks, order)
+			reversed = cfg.ChainOrder == ChainOrderRootFirst
+		}
+	}
+	for i := range pemBlocks {
+		if reversed {
+			i = len(pemBlocks) - 1 - i
+		}
+		fingerprint := sha256.Sum256(certs[i].Raw)
+		prepared.fingerprints = append(prepared.fingerprints, hex.EncodeToString(fingerprint[:]))
+		if cfg.SplitRoot && len(certs) > 1 && isSelfSigned(certs[i]) {
+			if prepared.rootPEM != nil {
+				prepared.splitErr = fmt.Errorf("certChain has more than one self-signed certificate, including %s", certs[i].Subject)
+			}
+			prepared.rootPEM = pemBlocks[i]
+			continue
+		}
+		prepared.pemData = append(prepared.pemData, pemBlocks[i]...)
+	}
+	prepared.certs, prepared.certIndexes = certs, certIndexes
+	if len(certs) > 0 {
+		prepared.chainErr = verifyChain(certs)
+	}
+	return prepared
+}
+
+// addAuthorities writes the certificate or timestamp authorities of source,
+// as named by authority, to the current document. Entries are prepared on up
+// to Config.Concurrency goroutines and written in index order.
+func (a *assembler) addAuthorities(source trustedRootSource, authority string) error {
+	cfg := a.cfg
+	root := a.result.Root
+	authorities, ok := source.trustedRoot[authority].([]interface{})
+	if !ok {
+		infof("No %s found", authority)
+		return nil
+	}
+
+	prepared := make([]*preparedAuthority, len(authorities))
+	a.forEach(len(authorities), func(index int) {
+		prepared[index] = a.prepareAuthority(authority, index, len(authorities), authorities[index])
+	})
+
+	for index, p := range prepared {
+		entryLog := logging.Entry(authority, index)
+		p.log.Flush()
+		entrySummary := a.result.Summary.addAuthority(authority, source.name, index, p.listed)
+		certs, certIndexes, pemData, rootPEM := p.certs, p.certIndexes, p.pemData, p.rootPEM
+
+		var leaf *x509.Certificate
+		if len(certs) > 0 {
+			leaf = certs[0]
+		}
+		entrySummary.setCertificates(certs)
+
+		// Selection comes first, so authorities left out do not fail
+		// --strict for the warnings raised preparing them.
+		if !selectAuthority(index, leaf, cfg.IncludeAuthorities, cfg.ExcludeAuthorities) {
+			entryLog.Infof("Skipping %s at index %d: not selected by --include-authority/--exclude-authority", authority, index)
+			entrySummary.markSkipped()
+			continue
+		}
+		if cfg.Strict && p.warning != nil {
+			return strictError(p.warning)
+		}
+		if p.tooLong {
+			entrySummary.markSkipped()
+			continue
+		}
+		if p.data == nil {
+			continue
+		}
+
+		if len(certs) > 0 && len(cfg.AllowedIssuers.values) > 0 {
+			rootCert := chainRoot(certs)
+			issuer := rootCert.Issuer
+			if !cfg.AllowedIssuers.allows(rootCert) {
+				if cfg.UnapprovedIssuerAction == IssuerActionFail {
+					return validationError("%s at index %d is issued by unapproved issuer %s", authority, index, issuer)
+				}
+				entryLog.Infof("Skipping %s at index %d: issued by unapproved issuer %s", authority, index, issuer)
+				entrySummary.markSkipped()
+				continue
+			}
+			entryLog.Infof("Accepting %s at index %d: issued by approved issuer %s", authority, index, issuer)
+		}
+
+		for i, cert := range certs {
+			if err := checkValidity(cert, time.Now()); err != nil {
+				if cfg.FailOnExpired {
+					return validationError("certificate %d of %s at index %d: %w", certIndexes[i], authority, index, err)
+				}
+				if err := a.warnf(entryLog, "Certificate %d of %s at index %d: %v", certIndexes[i], authority, index, err); err != nil {
+					return err
+				}
+			}
+			if err := weakCrypto(cert); err != nil {
+				if cfg.FailOnWeakCrypto {
+					return validationError("certificate %d of %s at index %d: %w", certIndexes[i], authority, index, err)
+				}
+				if err := a.warnf(entryLog, "Certificate %d of %s at index %d: %v", certIndexes[i], authority, index, err); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(certs) == 0 {
+			if cfg.FailOnEmptyChain {
+				return validationError("no certificate of %s at index %d could be converted", authority, index)
+			}
+			if err := a.warnf(entryLog, "Skipping %s at index %d: no certificate could be converted", authority, index); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := p.chainErr; err != nil {
+			if cfg.FailOnInvalidChain {
+				return validationError("invalid certChain for %s at index %d: %w", authority, index, err)
+			}
+			if err := a.warnf(entryLog, "Invalid certChain for %s at index %d: %v", authority, index, err); err != nil {
+				return err
+			}
+		}
+
+		subject := Subject{Organization: cfg.Organization, CommonName: cfg.CommonName}
+		if leaf != nil {
+			certOrganization, certCommonName := extractSubject(leaf)
+			if certOrganization != "" {
+				subject.Organization = certOrganization
+			}
+			if certCommonName != "" {
+				subject.CommonName = certCommonName
+			}
+			for _, field := range []struct {
+				name  string
+				value *string
+			}{{"organization", &subject.Organization}, {"commonName", &subject.CommonName}} {
+				if sanitized := sanitizeSubjectField(*field.value); sanitized != *field.value {
+					if err := a.warnf(entryLog, "Sanitized subject %s %q of %s at index %d to %q", field.name, *field.value, authority, index, sanitized); err != nil {
+						return err
+					}
+					*field.value = sanitized
+				}
+			}
+			subject.Email, subject.URI = extractSubjectIdentities(leaf)
+		}
+		if cfg.SubjectEmail != "" {
+			subject.Email = cfg.SubjectEmail
+		}
+
+		// Authorities carry their validity window; prefer the one declared in
+		// the trusted root over the certificates'. Timestamp authorities fall
+		// back to their leaf, certificate authorities to their root when
+		// DeriveCAValidFor is set.
+		validFor := readValidFor(p.data)
+		if validFor == nil && leaf != nil {
+			if authority == "timestampAuthorities" {
+				validFor = certificateValidFor(leaf)
+			} else if cfg.DeriveCAValidFor {
+				validFor = certificateValidFor(chainRoot(certs))
+			}
+		}
+
+		if cfg.SplitRoot {
+			if p.splitErr != nil {
+				return validationError("cannot split the root of %s at index %d: %w", authority, index, p.splitErr)
+			}
+			if rootPEM == nil {
+				if len(certs) == 1 && isSelfSigned(leaf) {
+					entryLog.Infof("Keeping the root of %s at index %d in its certChain: it is the only certificate", authority, index)
+				} else if err := a.warnf(entryLog, "No self-signed root to split off the certChain of %s at index %d", authority, index); err != nil {
+					return err
+				}
+			}
+		}
+
+		chainKey := string(pemData) + string(rootPEM)
+		if a.seen[authority][chainKey] {
+			entryLog.Infof("Skipping %s at index %d: duplicate certChain", authority, index)
+			entrySummary.markSkipped()
+			continue
+		}
+		a.seen[authority][chainKey] = true
+
+		target := a.offsets[authority]
+		if cfg.MergeMode == MergeUpsert {
+			var err error
+			target, err = upsertIndex(root, cfg.SigstoreKeysPath, authority, authorityIdentity(subject.Organization, subject.CommonName), a.claimed[authority])
+			if err != nil {
+				return validationError("failed to update TrustRoot: %w", err)
+			}
+		}
+		authorityURI := cfg.defaultURI(authority)
+		if mapped, ok := cfg.URIMap.lookup(index, subject.CommonName); ok {
+			authorityURI = mapped
+		} else if authorityURI == "" {
+			if rootURI, _ := p.data["uri"].(string); rootURI != "" {
+				entryLog.Debugf("Using URI %s from the trusted root for %s at index %d", rootURI, authority, index)
+				authorityURI = rootURI
+			} else if certURI := certificateURI(leaf); certURI != "" {
+				entryLog.Infof("Using URI %s from the certificate of %s at index %d", certURI, authority, index)
+				authorityURI = certURI
+			} else if err := a.warnf(entryLog, "No URI found for %s at index %d", authority, index); err != nil {
+				return err
+			}
+		}
+
+		entry := newAuthorityEntry(pemData, subject, authorityURI, validFor, cfg.CertChainEncoding)
+		if rootPEM != nil {
+			entry["trustRoot"] = encodeCertChain(rootPEM, cfg.CertChainEncoding)
+		}
+		node, err := updateSigstoreKeys(root, cfg.SigstoreKeysPath, authority, target, entry, a.entryTemplates[authority])
+		if err != nil {
+			return validationError("failed to update TrustRoot: %w", err)
+		}
+		if cfg.AnnotateFingerprints {
+			annotateFingerprints(node, p.fingerprints)
+		}
+		a.offsets[authority]++
+		a.result.Summary.markWritten(entrySummary)
+		a.written[node] = entrySummary
+		if cfg.OutputFormat == OutputPEMBundle && authority == "certificateAuthorities" {
+			if a.result.addRoots(certs) == 0 {
+				entryLog.Debugf("No self-signed root in the certChain of %s at index %d to bundle", authority, index)
+			}
+		}
+	}
+	infof("Processed %d %s", len(authorities), authority)
+	return nil
+}
+
+// addTransparencyLogs writes the transparency logs of source, as named by
+// logType, to the current document.
+func (a *assembler) addTransparencyLogs(source trustedRootSource, logType string) error {
+	root := a.result.Root
+	logs, ok := source.trustedRoot[logType].([]interface{})
+	if !ok {
+		infof("No %s found", logType)
+		return nil
+	}
+
+	for index, logEntry := range logs {
+		entryLog := logging.Entry(logType, index)
+		entryLog.Debugf("[%d/%d] Processing %s at index %d", index+1, len(logs), logType, index)
+
+		logData, ok := logEntry.(map[string]interface{})
+		if !ok {
+			if err := a.warnf(entryLog, "Invalid %s entry at index %d", logType, index); err != nil {
+				return err
+			}
+			continue
+		}
+
+		publicKeyData, ok := logData["publicKey"].(map[string]interface{})
+		if !ok {
+			if err := a.warnf(entryLog, "No publicKey found for %s at index %d", logType, index); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rawBytes, ok := publicKeyData["rawBytes"].(string)
+		if !ok {
+			if err := a.warnf(entryLog, "No rawBytes found for publicKey of %s at index %d", logType, index); err != nil {
+				return err
+			}
+			continue
+		}
+
+		der, err := decodeRawBytes(rawBytes, entryLog)
+		if err != nil {
+			if err := a.warnf(entryLog, "publicKey of %s at index %d: %v", logType, index, err); err != nil {
+				return err
+			}
+			continue
+		}
+		pemData, err := convertPublicKeyToPEM(der, entryLog)
+		if err != nil {
+			if err := a.warnf(entryLog, "publicKey of %s at index %d: %v", logType, index, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var logID string
+		if logIDData, ok := logData["logId"].(map[string]interface{}); ok {
+			logID, _ = logIDData["keyId"].(string)
+		}
+		if logID == "" {
+			if err := a.warnf(entryLog, "No logId found for %s at index %d", logType, index); err != nil {
+				return err
+			}
+		} else if a.cfg.VerifyLogIDs && !logIDMatches(logID, pemData, entryLog) {
+			if err := a.warnf(entryLog, "logId %s of %s at index %d is not the SHA-256 of its publicKey", logID, logType, index); err != nil {
+				return err
+			}
+		}
+
+		baseURL, _ := logData["baseUrl"].(string)
+		hashAlgorithm, _ := logData["hashAlgorithm"].(string)
+
+		if a.seen[logType][string(pemData)] {
+			entryLog.Infof("Skipping %s at index %d: duplicate publicKey", logType, index)
+			continue
+		}
+		a.seen[logType][string(pemData)] = true
+
+		section := transparencyLogKeys[logType]
+		target := a.offsets[logType]
+		if a.cfg.MergeMode == MergeUpsert {
+			target, err = upsertIndex(root, a.cfg.SigstoreKeysPath, section, baseURL, a.claimed[logType])
+			if err != nil {
+				return validationError("failed to update TrustRoot: %w", err)
+			}
+		}
+		entry := newTransparencyLogEntry(pemData, baseURL, hashAlgorithm, logID)
+		if _, err := updateSigstoreKeys(root, a.cfg.SigstoreKeysPath, section, target, entry, a.entryTemplates[section]); err != nil {
+			return validationError("failed to update TrustRoot: %w", err)
+		}
+		a.offsets[logType]++
+	}
+	infof("Processed %d %s", len(logs), logType)
+	return nil
+}
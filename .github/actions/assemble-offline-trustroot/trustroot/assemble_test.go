@@ -0,0 +1,311 @@
+package trustroot
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/falcorocks/AutoTrustRoot/logging"
+)
+
+// newTestChain returns a leaf, intermediate and root certificate, leaf
+// first, whose subjects are organization and the given common name.
+func newTestChain(t testing.TB, organization, commonName string) []*x509.Certificate {
+	t.Helper()
+	notBefore := time.Now().Add(-time.Hour)
+	var certs []*x509.Certificate
+	var parent *x509.Certificate
+	var parentKey *ecdsa.PrivateKey
+	for serial, name := range []string{commonName + " root", commonName + " intermediate", commonName} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(serial + 1)),
+			Subject:               pkix.Name{Organization: []string{organization}, CommonName: name},
+			NotBefore:             notBefore,
+			NotAfter:              notBefore.Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  serial < 2,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append([]*x509.Certificate{cert}, certs...)
+		parent, parentKey = cert, key
+	}
+	return certs
+}
+
+// certChainEntry returns the trusted root certChain listing certs in order.
+func certChainEntry(certs ...*x509.Certificate) map[string]interface{} {
+	var certificates []interface{}
+	for _, cert := range certs {
+		certificates = append(certificates, map[string]interface{}{"rawBytes": base64.StdEncoding.EncodeToString(cert.Raw)})
+	}
+	return map[string]interface{}{"certificates": certificates}
+}
+
+// testTemplate is a minimal TrustRoot template.
+const testTemplate = `apiVersion: policy.sigstore.dev/v1alpha1
+kind: TrustRoot
+metadata:
+  name: test
+spec:
+  sigstoreKeys:
+    certificateAuthorities: []
+    timestampAuthorities: []
+    tLogs: []
+    ctLogs: []
+`
+
+// newTestTrustedRoot returns a trusted root with certificateAuthorities
+// certificate authorities and a timestamp authority and transparency log.
+func newTestTrustedRoot(t testing.TB, certificateAuthorities int) map[string]interface{} {
+	t.Helper()
+	var authorities []interface{}
+	for i := 0; i < certificateAuthorities; i++ {
+		chain := newTestChain(t, "example.com", fmt.Sprintf("ca-%d", i))
+		authorities = append(authorities, map[string]interface{}{
+			"subject":   map[string]interface{}{"organization": "example.com", "commonName": fmt.Sprintf("ca-%d", i)},
+			"uri":       fmt.Sprintf("https://ca-%d.example.com", i),
+			"certChain": certChainEntry(chain...),
+			"validFor":  map[string]interface{}{"start": "2024-01-01T00:00:00Z"},
+		})
+	}
+	tsaChain := newTestChain(t, "example.com", "tsa")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(publicKey)
+
+	return map[string]interface{}{
+		"mediaType":              "application/vnd.dev.sigstore.trustedroot+json;version=0.1",
+		"certificateAuthorities": authorities,
+		"timestampAuthorities": []interface{}{map[string]interface{}{
+			"subject":   map[string]interface{}{"organization": "example.com", "commonName": "tsa"},
+			"uri":       "https://tsa.example.com",
+			"certChain": certChainEntry(tsaChain...),
+		}},
+		"tlogs": []interface{}{map[string]interface{}{
+			"baseUrl":       "https://rekor.example.com",
+			"hashAlgorithm": "SHA2_256",
+			"publicKey": map[string]interface{}{
+				"rawBytes":   base64.StdEncoding.EncodeToString(publicKey),
+				"keyDetails": "PKIX_ECDSA_P256_SHA_256",
+			},
+			"logId": map[string]interface{}{"keyId": base64.StdEncoding.EncodeToString(logID[:])},
+		}},
+	}
+}
+
+// testFS returns a filesystem holding testTemplate at "template.yaml" and
+// trustedRoot, JSON encoded, at "trusted_root.json".
+func testFS(t testing.TB, trustedRoot map[string]interface{}) fstest.MapFS {
+	t.Helper()
+	data, err := json.Marshal(trustedRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fstest.MapFS{
+		"template.yaml":     {Data: []byte(testTemplate)},
+		"trusted_root.json": {Data: data},
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	if err := logging.SetLevel("error"); err != nil {
+		b.Fatal(err)
+	}
+	cfg := Config{
+		FS:               testFS(b, newTestTrustedRoot(b, 50)),
+		TemplatePath:     "template.yaml",
+		TrustedRootPaths: []string{"trusted_root.json"},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := Build(context.Background(), cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.Summary.Count != 51 {
+			b.Fatalf("wrote %d authorities, want 51", result.Summary.Count)
+		}
+		if _, err := result.Render(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// buildTestTrustRoot builds and renders the TrustRoot of cfg with the
+// trusted root and template of fsys.
+func buildTestTrustRoot(t *testing.T, fsys fstest.MapFS, cfg Config) []byte {
+	t.Helper()
+	cfg.FS = fsys
+	cfg.TemplatePath = "template.yaml"
+	cfg.TrustedRootPaths = []string{"trusted_root.json"}
+	result, err := Build(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := result.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestBuildIsDeterministic(t *testing.T) {
+	trustedRoot := newTestTrustedRoot(t, 5)
+	want := buildTestTrustRoot(t, testFS(t, trustedRoot), Config{})
+
+	authorities := trustedRoot["certificateAuthorities"].([]interface{})
+	reversed := make([]interface{}, len(authorities))
+	for i, authority := range authorities {
+		reversed[len(authorities)-1-i] = authority
+	}
+	trustedRoot["certificateAuthorities"] = reversed
+	if got := buildTestTrustRoot(t, testFS(t, trustedRoot), Config{}); string(got) != string(want) {
+		t.Errorf("reversing the authorities changed the TrustRoot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBuildUpsertKeepsOrder(t *testing.T) {
+	trustedRoot := newTestTrustedRoot(t, 3)
+	fsys := testFS(t, trustedRoot)
+	out := buildTestTrustRoot(t, fsys, Config{})
+
+	// Reverse the certificate authorities of the TrustRoot, which the
+	// trusted root would write sorted, and upsert into it.
+	root, err := parseYAML(out, "trustroot.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigstoreKeys, err := DefaultSigstoreKeysPath.walk(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := mappingValue(sigstoreKeys, "certificateAuthorities").Content
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	existing, err := marshalYAML(root, DefaultYAMLIndent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys["template.yaml"] = &fstest.MapFile{Data: existing}
+
+	if got := buildTestTrustRoot(t, fsys, Config{MergeMode: MergeUpsert}); string(got) != string(existing) {
+		t.Errorf("upserting reordered the TrustRoot:\n%s\nwant:\n%s", got, existing)
+	}
+}
+
+func TestBuildSkipsLongChains(t *testing.T) {
+	trustedRoot := newTestTrustedRoot(t, 2)
+	// Repeat the leaf of the first authority so its certChain lists four
+	// certificates, one more than allowed.
+	certChain := trustedRoot["certificateAuthorities"].([]interface{})[0].(map[string]interface{})["certChain"].(map[string]interface{})
+	certificates := certChain["certificates"].([]interface{})
+	certChain["certificates"] = append(certificates, certificates[0])
+	fsys := testFS(t, trustedRoot)
+	cfg := Config{
+		FS:               fsys,
+		TemplatePath:     "template.yaml",
+		TrustedRootPaths: []string{"trusted_root.json"},
+		MaxChainLength:   3,
+	}
+
+	result, err := Build(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Summary.Authorities[0]; got.Listed != 4 || got.Status != StatusSkipped {
+		t.Errorf("authority with a long certChain has %d certificates listed and status %q, want 4 and %q", got.Listed, got.Status, StatusSkipped)
+	}
+	// The CLI fails without --allow-partial when any authority failed.
+	if complete, partial, failed := result.Summary.Outcomes(); complete != 2 || partial != 0 || failed != 0 {
+		t.Errorf("Outcomes() = %d, %d, %d, want 2, 0, 0", complete, partial, failed)
+	}
+
+	cfg.Strict = true
+	_, err = Build(context.Background(), cfg)
+	var buildErr *Error
+	if !errors.As(err, &buildErr) || buildErr.Kind != KindStrict || !errors.Is(err, ErrChainTooLong) {
+		t.Errorf("Build() with Strict error = %v, want a strict error wrapping %v", err, ErrChainTooLong)
+	}
+}
+
+func TestPrepareAuthorityDropsDuplicateCertificates(t *testing.T) {
+	chain := newTestChain(t, "example.com", "example")
+	leaf, intermediate, root := chain[0], chain[1], chain[2]
+
+	a := &assembler{cfg: Config{}.withDefaults()}
+	p := a.prepareAuthority("certificateAuthorities", 0, 1, map[string]interface{}{
+		"certChain": certChainEntry(leaf, intermediate, leaf, root, intermediate),
+	})
+	if p.warning != nil {
+		t.Fatalf("prepareAuthority() warned: %v", p.warning)
+	}
+	if p.listed != 5 {
+		t.Errorf("listed = %d, want 5", p.listed)
+	}
+	if len(p.certs) != len(chain) {
+		t.Fatalf("got %d certificates, want %d", len(p.certs), len(chain))
+	}
+	for i, cert := range chain {
+		if !p.certs[i].Equal(cert) {
+			t.Errorf("certificate %d is %s, want %s", i, p.certs[i].Subject, cert.Subject)
+		}
+	}
+	if want := []int{0, 1, 3}; !reflect.DeepEqual(p.certIndexes, want) {
+		t.Errorf("certIndexes = %v, want %v", p.certIndexes, want)
+	}
+	if len(p.fingerprints) != 3 {
+		t.Errorf("fingerprints = %v, want 3", p.fingerprints)
+	}
+
+	var blocks int
+	for rest := p.pemData; ; blocks++ {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+	}
+	if blocks != 3 {
+		t.Errorf("pemData holds %d certificates, want 3", blocks)
+	}
+	if p.chainErr != nil {
+		t.Errorf("chainErr = %v, want nil", p.chainErr)
+	}
+}
@@ -0,0 +1,58 @@
+package trustroot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"sort"
+)
+
+// OutputPEMBundle is the Config.OutputFormat that renders, instead of a
+// TrustRoot, a PEM bundle of the self-signed root certificates of the
+// certificate authorities written.
+const OutputPEMBundle = "pem-bundle"
+
+// addRoots records the self-signed certificates of certs, the chain of a
+// certificate authority written, for OutputPEMBundle. It returns how many
+// there were.
+func (r *Result) addRoots(certs []*x509.Certificate) int {
+	added := 0
+	for _, cert := range certs {
+		if isSelfSigned(cert) {
+			r.roots = append(r.roots, cert)
+			added++
+		}
+	}
+	return added
+}
+
+// marshalPEMBundle returns roots PEM encoded one after the other, without
+// duplicates and sorted by subject and then by SHA-256 fingerprint, so the
+// same roots always render the same bytes.
+func marshalPEMBundle(roots []*x509.Certificate) ([]byte, error) {
+	byFingerprint := map[[sha256.Size]byte]*x509.Certificate{}
+	for _, root := range roots {
+		byFingerprint[sha256.Sum256(root.Raw)] = root
+	}
+	fingerprints := make([][sha256.Size]byte, 0, len(byFingerprint))
+	for fingerprint := range byFingerprint {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	sort.Slice(fingerprints, func(i, j int) bool {
+		a, b := byFingerprint[fingerprints[i]], byFingerprint[fingerprints[j]]
+		if subjectA, subjectB := a.Subject.String(), b.Subject.String(); subjectA != subjectB {
+			return subjectA < subjectB
+		}
+		return bytes.Compare(fingerprints[i][:], fingerprints[j][:]) < 0
+	})
+
+	var out []byte
+	for _, fingerprint := range fingerprints {
+		pemBytes, err := encodePEM(BlockTypeCertificate, byFingerprint[fingerprint].Raw)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pemBytes...)
+	}
+	return out, nil
+}
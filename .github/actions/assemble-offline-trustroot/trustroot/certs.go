@@ -0,0 +1,441 @@
+package trustroot
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// rawBytesEncodings lists the base64 alphabets rawBytes are decoded with, in
+// the order they are tried.
+var rawBytesEncodings = []struct {
+	name     string
+	encoding *base64.Encoding
+}{
+	{"standard", base64.StdEncoding},
+	{"unpadded standard", base64.RawStdEncoding},
+	{"URL-safe", base64.URLEncoding},
+	{"unpadded URL-safe", base64.RawURLEncoding},
+}
+
+// decodeRawBytes decodes a rawBytes value of a trusted root, which is
+// standard base64 but some tooling writes without padding or with the
+// URL-safe alphabet. The alphabet used is logged to log when not standard.
+func decodeRawBytes(rawBytes string, log logger) ([]byte, error) {
+	var firstErr error
+	for _, candidate := range rawBytesEncodings {
+		data, err := candidate.encoding.DecodeString(rawBytes)
+		if err == nil {
+			if candidate.encoding != base64.StdEncoding {
+				log.Debugf("Decoded rawBytes as %s base64", candidate.name)
+			}
+			return data, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, fmt.Errorf("%w: %w", ErrDecodeRawBytes, firstErr)
+}
+
+// checkDER reports an error when data, the decoded rawBytes of a
+// certificate, is neither PEM nor plausibly DER: a DER certificate is a
+// SEQUENCE (tag 0x30) whose encoded length fits in data. This catches
+// truncated or otherwise mangled rawBytes before they fail to parse with a
+// less telling x509 error.
+func checkDER(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		return nil
+	}
+	if len(data) < 2 {
+		return fmt.Errorf("%w: only %d byte(s)", ErrNotDER, len(data))
+	}
+	if data[0] != 0x30 {
+		return fmt.Errorf("%w: starts with 0x%02x instead of a SEQUENCE", ErrNotDER, data[0])
+	}
+
+	length, header := int(data[1]), 2
+	if data[1]&0x80 != 0 {
+		n := int(data[1] & 0x7f)
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return fmt.Errorf("%w: invalid length", ErrNotDER)
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		header += n
+	}
+	if header+length > len(data) {
+		return fmt.Errorf("%w: truncated to %d of %d bytes", ErrNotDER, len(data), header+length)
+	}
+	return nil
+}
+
+// PEM block types written by ConvertToPEM.
+const (
+	BlockTypeCertificate        = "CERTIFICATE"
+	BlockTypeTrustedCertificate = "TRUSTED CERTIFICATE"
+	BlockTypePublicKey          = "PUBLIC KEY"
+)
+
+// ConvertToPEM returns der PEM encoded as a block of blockType. Certificates
+// (BlockTypeCertificate or BlockTypeTrustedCertificate) are validated and
+// returned parsed as well; the input is usually DER, but PEM encoded
+// certificates found in some trusted roots are accepted too. Any other block
+// type is encoded as is and the returned certificate is nil.
+func ConvertToPEM(der []byte, blockType string) ([]byte, *x509.Certificate, error) {
+	return convertToPEM(der, blockType, packageLogger{})
+}
+
+// convertToPEM is ConvertToPEM logging to log.
+func convertToPEM(der []byte, blockType string, log logger) ([]byte, *x509.Certificate, error) {
+	if blockType != BlockTypeCertificate && blockType != BlockTypeTrustedCertificate {
+		pemBytes, err := encodePEM(blockType, der)
+		return pemBytes, nil, err
+	}
+
+	if block, _ := pem.Decode(der); block != nil && (block.Type == BlockTypeCertificate || block.Type == BlockTypeTrustedCertificate) {
+		log.Debugf("Detected PEM encoded certificate data")
+		der = block.Bytes
+	} else {
+		log.Debugf("Detected DER encoded certificate data")
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrParseCertificate, err)
+	}
+	pemBytes, err := encodePEM(blockType, der)
+	if err != nil {
+		return nil, nil, newCertificateError(cert, err)
+	}
+	return pemBytes, cert, nil
+}
+
+// encodePEM returns der PEM encoded as a block of blockType.
+func encodePEM(blockType string, der []byte) ([]byte, error) {
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if pemBytes == nil {
+		return nil, fmt.Errorf("%w as %q", ErrEncodePEM, blockType)
+	}
+	return pemBytes, nil
+}
+
+// convertPublicKeyToPEM returns der, a PKIX public key, PEM encoded as a
+// BlockTypePublicKey block. The key must parse and be an ECDSA, RSA or
+// Ed25519 key; its algorithm and size are logged to log. A key that is PEM
+// encoded already is accepted too.
+func convertPublicKeyToPEM(der []byte, log logger) ([]byte, error) {
+	if block, _ := pem.Decode(der); block != nil && block.Type == BlockTypePublicKey {
+		der = block.Bytes
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParsePublicKey, err)
+	}
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		log.Debugf("Detected ECDSA %s %d-bit public key", key.Curve.Params().Name, key.Curve.Params().BitSize)
+	case *rsa.PublicKey:
+		log.Debugf("Detected RSA %d-bit public key", key.N.BitLen())
+	case ed25519.PublicKey:
+		log.Debugf("Detected Ed25519 %d-bit public key", len(key)*8)
+	default:
+		return nil, fmt.Errorf("%w %T", ErrUnsupportedKey, key)
+	}
+	return encodePEM(BlockTypePublicKey, der)
+}
+
+// logIDMatches reports whether logID, the base64 keyId of a transparency
+// log, is the SHA-256 of its public key, the PEM encoded PKIX key pemData.
+func logIDMatches(logID string, pemData []byte, log logger) bool {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return false
+	}
+	keyID, err := decodeRawBytes(logID, log)
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(block.Bytes)
+	return bytes.Equal(keyID, digest[:])
+}
+
+// Values of Config.ChainOrder.
+const (
+	ChainOrderAsIs      = "as-is"
+	ChainOrderLeafFirst = "leaf-first"
+	ChainOrderRootFirst = "root-first"
+)
+
+// leafFirstOrder returns the indices of certs ordered from the leaf to the
+// root by matching each certificate's issuer to the subject of the next. A
+// self-signed root, whose issuer is its own subject, ends the chain. It fails
+// when certs do not form a single connected chain.
+func leafFirstOrder(certs []*x509.Certificate) ([]int, error) {
+	issues := func(issuer, cert *x509.Certificate) bool {
+		return issuer != cert && bytes.Equal(issuer.RawSubject, cert.RawIssuer)
+	}
+
+	leaf := -1
+	for i, cert := range certs {
+		isIssuer := false
+		for _, other := range certs {
+			if issues(cert, other) {
+				isIssuer = true
+				break
+			}
+		}
+		if !isIssuer {
+			if leaf != -1 {
+				return nil, fmt.Errorf("certificates %d and %d both look like leaves", leaf, i)
+			}
+			leaf = i
+		}
+	}
+	if leaf == -1 {
+		return nil, errors.New("no leaf certificate found")
+	}
+
+	order := []int{leaf}
+	visited := map[int]bool{leaf: true}
+	for current := leaf; !bytes.Equal(certs[current].RawIssuer, certs[current].RawSubject); {
+		next := -1
+		for i, cert := range certs {
+			if !visited[i] && issues(cert, certs[current]) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			break
+		}
+		order = append(order, next)
+		visited[next] = true
+		current = next
+	}
+	if len(order) != len(certs) {
+		return nil, fmt.Errorf("only %d of %d certificates form a chain from the leaf", len(order), len(certs))
+	}
+	return order, nil
+}
+
+// permute returns values reordered so that element i is values[order[i]].
+func permute[T any](values []T, order []int) []T {
+	permuted := make([]T, len(order))
+	for i, j := range order {
+		permuted[i] = values[j]
+	}
+	return permuted
+}
+
+// checkValidity reports an error when cert is expired or not yet valid at now.
+func checkValidity(cert *x509.Certificate, now time.Time) error {
+	if now.After(cert.NotAfter) {
+		return newCertificateError(cert, fmt.Errorf("%w on %s", ErrExpired, cert.NotAfter.Format(time.RFC3339)))
+	}
+	if now.Before(cert.NotBefore) {
+		return newCertificateError(cert, fmt.Errorf("%w until %s", ErrNotYetValid, cert.NotBefore.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// minRSAKeyBits is the smallest RSA key size not reported by weakCrypto.
+const minRSAKeyBits = 2048
+
+// weakCrypto reports an error when cert is signed with an MD5 or SHA-1
+// based algorithm or holds an RSA key shorter than minRSAKeyBits.
+func weakCrypto(cert *x509.Certificate) error {
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return newCertificateError(cert, fmt.Errorf("%w: signed with %s", ErrWeakCrypto, cert.SignatureAlgorithm))
+	}
+	if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && key.N.BitLen() < minRSAKeyBits {
+		return newCertificateError(cert, fmt.Errorf("%w: %d-bit %s key", ErrWeakCrypto, key.N.BitLen(), cert.PublicKeyAlgorithm))
+	}
+	return nil
+}
+
+// verifyChain checks that certs, ordered from leaf to root, form a valid
+// chain: every certificate must verify against a pool holding the last
+// certificate as root and the ones in between as intermediates. The error
+// identifies the first certificate that is not signed by its successor.
+func verifyChain(certs []*x509.Certificate) error {
+	if len(certs) < 2 {
+		return nil
+	}
+
+	root := certs[len(certs)-1]
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1 : len(certs)-1] {
+		intermediates.AddCert(cert)
+	}
+
+	// Expiry is reported separately, so verify at a time every certificate
+	// of the chain was valid rather than now.
+	verifyTime := root.NotBefore
+	for _, cert := range certs {
+		if cert.NotBefore.After(verifyTime) {
+			verifyTime = cert.NotBefore
+		}
+	}
+
+	for i, cert := range certs[:len(certs)-1] {
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   verifyTime,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err == nil {
+			continue
+		}
+		for j := i; j < len(certs)-1; j++ {
+			if sigErr := certs[j].CheckSignatureFrom(certs[j+1]); sigErr != nil {
+				return fmt.Errorf("certificate %d (%s) is not signed by certificate %d (%s): %w", j, certs[j].Subject, j+1, certs[j+1].Subject, sigErr)
+			}
+		}
+		return fmt.Errorf("certificate %d (%s) does not chain up to %s: %w", i, cert.Subject, root.Subject, err)
+	}
+	return nil
+}
+
+// readValidFor returns the start and end of the validFor object of a trusted
+// root entry, or nil when the entry declares none.
+func readValidFor(entry map[string]interface{}) map[string]string {
+	validForData, ok := entry["validFor"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	validFor := map[string]string{}
+	for _, key := range []string{"start", "end"} {
+		if value, ok := validForData[key].(string); ok && value != "" {
+			validFor[key] = value
+		}
+	}
+	if len(validFor) == 0 {
+		return nil
+	}
+	return validFor
+}
+
+// chainRoot returns the self-signed certificate of certs, or the last one
+// when none is self-signed.
+func chainRoot(certs []*x509.Certificate) *x509.Certificate {
+	for _, cert := range certs {
+		if isSelfSigned(cert) {
+			return cert
+		}
+	}
+	return certs[len(certs)-1]
+}
+
+// isSelfSigned reports whether cert is issued by its own subject.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject)
+}
+
+// certificateValidFor returns the validity window of cert as a validFor
+// object with RFC 3339 timestamps.
+func certificateValidFor(cert *x509.Certificate) map[string]string {
+	return map[string]string{
+		"start": cert.NotBefore.UTC().Format(time.RFC3339),
+		"end":   cert.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
+
+// certificateURI returns a URI for the authority whose certificate is cert,
+// taken from its URI SANs, CRL distribution points, OCSP servers or issuing
+// certificate URLs, in that order. https URIs are preferred over others.
+// It returns "" when cert carries no URI.
+func certificateURI(cert *x509.Certificate) string {
+	var candidates []string
+	for _, u := range cert.URIs {
+		candidates = append(candidates, u.String())
+	}
+	candidates = append(candidates, cert.CRLDistributionPoints...)
+	candidates = append(candidates, cert.OCSPServer...)
+	candidates = append(candidates, cert.IssuingCertificateURL...)
+
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, "https://") {
+			return candidate
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0]
+	}
+	return ""
+}
+
+// Subject is the subject written for an authority.
+type Subject struct {
+	Organization string
+	CommonName   string
+	Email        string
+	URI          string
+}
+
+// fields returns the subject as written to the TrustRoot. email and uri are
+// omitted when empty.
+func (s Subject) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"organization": s.Organization,
+		"commonName":   s.CommonName,
+	}
+	if s.Email != "" {
+		fields["email"] = s.Email
+	}
+	if s.URI != "" {
+		fields["uri"] = s.URI
+	}
+	return fields
+}
+
+// extractSubjectIdentities returns the first email address and URI in the
+// Subject Alternative Name extension of cert. Either value is empty when the
+// extension does not carry it.
+func extractSubjectIdentities(cert *x509.Certificate) (email, uri string) {
+	if len(cert.EmailAddresses) > 0 {
+		email = cert.EmailAddresses[0]
+	}
+	if len(cert.URIs) > 0 {
+		uri = cert.URIs[0].String()
+	}
+	return email, uri
+}
+
+// extractSubject returns the subject organization and common name of cert.
+// Either value is empty when the certificate does not carry it.
+func extractSubject(cert *x509.Certificate) (organization, commonName string) {
+	if len(cert.Subject.Organization) > 0 {
+		organization = cert.Subject.Organization[0]
+	}
+	return organization, cert.Subject.CommonName
+}
+
+// sanitizeSubjectField returns value with invalid UTF-8 replaced by U+FFFD
+// and control characters removed, so it renders as plain text in YAML.
+func sanitizeSubjectField(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(value, "\uFFFD"))
+}
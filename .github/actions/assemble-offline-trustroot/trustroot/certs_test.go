@@ -0,0 +1,100 @@
+package trustroot
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecodeRawBytes(t *testing.T) {
+	// 0xfb 0xff 0xfe encodes to "+//+" in the standard alphabet and "-__-"
+	// in the URL-safe one; the trailing 0x01 requires padding.
+	want := []byte{0xfb, 0xff, 0xfe, 0x01}
+	for _, test := range []struct {
+		name     string
+		rawBytes string
+		want     []byte
+		wantErr  error
+	}{
+		{name: "standard", rawBytes: "+//+AQ==", want: want},
+		{name: "unpadded standard", rawBytes: "+//+AQ", want: want},
+		{name: "URL-safe", rawBytes: "-__-AQ==", want: want},
+		{name: "unpadded URL-safe", rawBytes: "-__-AQ", want: want},
+		{name: "empty", rawBytes: "", want: []byte{}},
+		{name: "mixed alphabets", rawBytes: "+__+AQ==", wantErr: ErrDecodeRawBytes},
+		{name: "invalid character", rawBytes: "+//+AQ=!", wantErr: ErrDecodeRawBytes},
+		{name: "truncated", rawBytes: "+//+A", wantErr: ErrDecodeRawBytes},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeRawBytes(test.rawBytes, packageLogger{})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("decodeRawBytes(%q) error = %v, want %v", test.rawBytes, err, test.wantErr)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("decodeRawBytes(%q) = %x, want %x", test.rawBytes, got, test.want)
+			}
+		})
+	}
+}
+
+func TestConvertToPEM(t *testing.T) {
+	cert := newTestChain(t, "example.com", "example")[0]
+	pemBytes, err := encodePEM(BlockTypeCertificate, cert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		der     []byte
+		wantErr error
+	}{
+		{name: "DER", der: cert.Raw},
+		{name: "PEM", der: pemBytes},
+		{name: "truncated DER", der: cert.Raw[:len(cert.Raw)/2], wantErr: ErrParseCertificate},
+		{name: "not a certificate", der: []byte{0x30, 0x03, 0x02, 0x01, 0x01}, wantErr: ErrParseCertificate},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, parsed, err := convertToPEM(test.der, BlockTypeCertificate, packageLogger{})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("convertToPEM() error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr != nil {
+				return
+			}
+			if !bytes.Equal(got, pemBytes) || !parsed.Equal(cert) {
+				t.Errorf("convertToPEM() = %q, %v, want %q, %v", got, parsed.Subject, pemBytes, cert.Subject)
+			}
+		})
+	}
+}
+
+func TestCheckValidity(t *testing.T) {
+	cert := newTestChain(t, "example.com", "example")[0]
+	for _, test := range []struct {
+		name    string
+		now     time.Time
+		wantErr error
+	}{
+		{name: "valid", now: cert.NotBefore.Add(time.Minute)},
+		{name: "expired", now: cert.NotAfter.Add(time.Minute), wantErr: ErrExpired},
+		{name: "not yet valid", now: cert.NotBefore.Add(-time.Minute), wantErr: ErrNotYetValid},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkValidity(cert, test.now)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("checkValidity() error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr == nil {
+				return
+			}
+			var certErr *CertificateError
+			if !errors.As(err, &certErr) {
+				t.Fatalf("checkValidity() error = %v, want a *CertificateError", err)
+			}
+			if certErr.Subject != cert.Subject.String() || certErr.Serial != cert.SerialNumber.String() {
+				t.Errorf("CertificateError identifies %s (serial %s), want %s (serial %s)", certErr.Subject, certErr.Serial, cert.Subject, cert.SerialNumber)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package trustroot
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// AuthorityCheck is the outcome of checking one certificate or timestamp
+// authority of a trusted root with Check.
+type AuthorityCheck struct {
+	Authority string
+	Source    string
+	Index     int
+	Subject   string
+	// Problems describes why the authority is invalid, empty when it is
+	// valid.
+	Problems []error
+}
+
+// Passed reports whether the authority has no problem.
+func (c AuthorityCheck) Passed() bool {
+	return len(c.Problems) == 0
+}
+
+// Check reads the trusted roots cfg describes and checks every certificate
+// and timestamp authority: that its certificates decode and parse, form a
+// valid chain and are currently valid. Weak cryptography is a problem only
+// with Config.FailOnWeakCrypto. No template is read and nothing is written.
+func Check(ctx context.Context, cfg Config) ([]AuthorityCheck, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	a := &assembler{
+		cfg:     cfg,
+		fetcher: &rootFetcher{fsys: cfg.FS, client: &http.Client{Timeout: cfg.HTTPTimeout}, retries: cfg.Retries, retryBackoff: cfg.RetryBackoff},
+	}
+	sources, err := a.readSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var checks []AuthorityCheck
+	now := time.Now()
+	for _, source := range sources {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		canonicalizeTrustedRoot(source.trustedRoot, source.name)
+		for _, authority := range []string{"certificateAuthorities", "timestampAuthorities"} {
+			authorities, _ := source.trustedRoot[authority].([]interface{})
+			prepared := make([]*preparedAuthority, len(authorities))
+			a.forEach(len(authorities), func(index int) {
+				prepared[index] = a.prepareAuthority(authority, index, len(authorities), authorities[index])
+			})
+
+			for index, p := range prepared {
+				p.log.Flush()
+				check := AuthorityCheck{Authority: authority, Source: source.name, Index: index, Problems: p.warnings}
+				if len(p.certs) > 0 {
+					check.Subject = p.certs[0].Subject.String()
+				} else if p.data != nil {
+					check.Problems = append(check.Problems, newWarning("No certificate could be converted"))
+				}
+				if p.chainErr != nil {
+					check.Problems = append(check.Problems, newWarning("Invalid certChain: %v", p.chainErr))
+				}
+				for i, cert := range p.certs {
+					if err := checkValidity(cert, now); err != nil {
+						check.Problems = append(check.Problems, newWarning("Certificate %d: %v", p.certIndexes[i], err))
+					}
+					if err := weakCrypto(cert); err != nil && cfg.FailOnWeakCrypto {
+						check.Problems = append(check.Problems, newWarning("Certificate %d: %v", p.certIndexes[i], err))
+					}
+				}
+				checks = append(checks, check)
+			}
+		}
+	}
+	return checks, nil
+}
@@ -0,0 +1,583 @@
+package trustroot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Values of Config.MergeMode.
+const (
+	MergeReplace = "replace"
+	MergeUpsert  = "upsert"
+)
+
+// transparencyLogKeys maps the trusted_root.json transparency log arrays to
+// their spec.sigstoreKeys counterparts in the TrustRoot.
+var transparencyLogKeys = map[string]string{
+	"tlogs":  "tLogs",
+	"ctlogs": "ctLogs",
+}
+
+// hashAlgorithms maps trusted_root.json hash algorithm names to the names
+// accepted by the TrustRoot.
+var hashAlgorithms = map[string]string{
+	"SHA2_256": "sha-256",
+	"SHA2_384": "sha-384",
+	"SHA2_512": "sha-512",
+}
+
+// outputFormats maps each supported Config.OutputFormat to its marshaller,
+// which is passed Config.YAMLIndent.
+var outputFormats = map[string]func(root *yaml.Node, indent int) ([]byte, error){
+	"yaml": marshalYAML,
+	"json": marshalJSON,
+}
+
+// DefaultYAMLIndent is the number of spaces YAML output is indented by when
+// Config.YAMLIndent is zero.
+const DefaultYAMLIndent = 2
+
+// maxYAMLIndent is the widest indentation the YAML encoder supports.
+const maxYAMLIndent = 9
+
+// marshalYAML marshals the TrustRoot document root as YAML indented by
+// indent spaces. Scalars are never folded, so certChain stays on one line.
+func marshalYAML(root *yaml.Node, indent int) ([]byte, error) {
+	return marshalYAMLStream([]*yaml.Node{root}, indent, false)
+}
+
+// marshalYAMLStream marshals documents as a YAML stream indented by indent
+// spaces. With separator, every document, including the first, starts with
+// "---".
+func marshalYAMLStream(documents []*yaml.Node, indent int, separator bool) ([]byte, error) {
+	var buf bytes.Buffer
+	if separator {
+		buf.WriteString("---\n")
+	}
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(indent)
+	for _, document := range documents {
+		if err := encoder.Encode(document); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalJSON marshals the TrustRoot document root as JSON indented by two
+// spaces and terminated by a newline.
+func marshalJSON(root *yaml.Node, _ int) ([]byte, error) {
+	var v interface{}
+	if err := root.Decode(&v); err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// rfc1123Label matches a lowercase RFC 1123 DNS label.
+var rfc1123Label = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// isRFC1123Label reports whether value is a valid Kubernetes object name
+// under the RFC 1123 label rules.
+func isRFC1123Label(value string) bool {
+	return len(value) <= 63 && rfc1123Label.MatchString(value)
+}
+
+// setMetadata sets metadata.<key> of the TrustRoot document root to value,
+// creating the metadata section when the template has none.
+func setMetadata(root *yaml.Node, key, value string) {
+	metadata := mappingValue(root.Content[0], "metadata")
+	if metadata == nil {
+		metadata = &yaml.Node{Kind: yaml.MappingNode}
+		root.Content[0].Content = append(root.Content[0].Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "metadata"}, metadata)
+	}
+	if metadata.Kind != yaml.MappingNode {
+		*metadata = yaml.Node{Kind: yaml.MappingNode}
+	}
+
+	if node := mappingValue(metadata, key); node != nil {
+		*node = yaml.Node{Kind: yaml.ScalarNode, Value: value, LineComment: node.LineComment}
+		return
+	}
+	metadata.Content = append(metadata.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &yaml.Node{Kind: yaml.ScalarNode, Value: value})
+}
+
+// suffixName appends suffix to metadata.name of the TrustRoot document root,
+// keeping the names of documents rendered into one stream distinct.
+func suffixName(root *yaml.Node, suffix string) {
+	metadata := mappingValue(root.Content[0], "metadata")
+	if metadata == nil || metadata.Kind != yaml.MappingNode {
+		return
+	}
+	if name := mappingValue(metadata, "name"); name != nil && name.Kind == yaml.ScalarNode {
+		name.Value += suffix
+	}
+}
+
+// Values of Config.CertChainEncoding.
+const (
+	CertChainBase64 = "base64"
+	CertChainPEM    = "pem"
+)
+
+// decodeCertChain returns the PEM data of a certChain or publicKey value of a
+// TrustRoot, which is either base64 encoded or the PEM itself.
+func decodeCertChain(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN ") {
+		return []byte(value), nil
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+// ValidateTemplate checks that the TrustRoot template at path in fsys can be
+// loaded, with vars substituted, and has the sigstoreKeys mapping at keysPath
+// the assembler writes to. With createMissing, parts of keysPath may be
+// missing but not be anything other than a mapping. With strictVars, no
+// placeholder may be left unsubstituted.
+func ValidateTemplate(fsys fs.FS, path string, keysPath SigstoreKeysPath, createMissing bool, vars TemplateVars, strictVars bool) error {
+	root, err := loadTemplate(fsys, path, vars, strictVars)
+	if err != nil {
+		return inputError("failed to load TrustRoot template: %w", err)
+	}
+	if _, err := keysPath.walk(root, createMissing); err != nil {
+		return validationError("%s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadYAML reads and parses the TrustRoot document at filePath in fsys. The
+// document is kept as a yaml.Node so comments and key order survive
+// re-serialization.
+func LoadYAML(fsys fs.FS, filePath string) (*yaml.Node, error) {
+	data, err := fs.ReadFile(fsys, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return parseYAML(data, filePath)
+}
+
+// parseYAML parses data, the TrustRoot document read from filePath.
+func parseYAML(data []byte, filePath string) (*yaml.Node, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filePath, err)
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s is not a YAML mapping", filePath)
+	}
+	return &root, nil
+}
+
+// newAuthorityEntry returns the spec.sigstoreKeys entry of an authority.
+// validFor is omitted when nil. certChain is written as encoded by
+// certChainEncoding.
+func newAuthorityEntry(pemData []byte, subject Subject, uri string, validFor map[string]string, certChainEncoding string) map[string]interface{} {
+	entry := map[string]interface{}{
+		"subject":   subject.fields(),
+		"uri":       uri,
+		"certChain": encodeCertChain(pemData, certChainEncoding),
+	}
+	if validFor != nil {
+		entry["validFor"] = validFor
+	}
+	return entry
+}
+
+// encodeCertChain returns the value of a certChain holding pemData, encoded
+// as certChainEncoding.
+func encodeCertChain(pemData []byte, certChainEncoding string) interface{} {
+	if certChainEncoding == CertChainPEM {
+		return &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.LiteralStyle, Value: string(pemData)}
+	}
+	return base64.StdEncoding.EncodeToString(pemData)
+}
+
+// newTransparencyLogEntry returns the spec.sigstoreKeys entry of a transparency
+// log.
+func newTransparencyLogEntry(pemData []byte, baseURL, hashAlgorithm, logID string) map[string]interface{} {
+	if name, ok := hashAlgorithms[hashAlgorithm]; ok {
+		hashAlgorithm = name
+	}
+	return map[string]interface{}{
+		"baseURL":       baseURL,
+		"hashAlgorithm": hashAlgorithm,
+		"publicKey":     base64.StdEncoding.EncodeToString(pemData),
+		"logID":         logID,
+	}
+}
+
+// updateSigstoreKeys sets entry at index of the named list of the
+// sigstoreKeys mapping at keysPath in the TrustRoot document root, leaving
+// the rest of the document untouched. entry is merged over template unless
+// template is nil. The written node is returned.
+func updateSigstoreKeys(root *yaml.Node, keysPath SigstoreKeysPath, section string, index int, entry map[string]interface{}, template *yaml.Node) (*yaml.Node, error) {
+	sigstoreKeys, err := keysPath.walk(root, false)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := mappingValue(sigstoreKeys, section)
+	if entries == nil {
+		entries = &yaml.Node{}
+		sigstoreKeys.Content = append(sigstoreKeys.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section}, entries)
+	}
+	if entries.Kind != yaml.SequenceNode {
+		*entries = yaml.Node{Kind: yaml.SequenceNode, HeadComment: entries.HeadComment, LineComment: entries.LineComment}
+	}
+	// Templates usually declare empty lists as "[]" or "{}"; render filled
+	// ones in block style.
+	sigstoreKeys.Style = 0
+	entries.Style = 0
+	entries.Tag = ""
+
+	for len(entries.Content) <= index {
+		entries.Content = append(entries.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"})
+	}
+	var node yaml.Node
+	if err := node.Encode(entry); err != nil {
+		return nil, err
+	}
+	if template != nil {
+		mergeEntryTemplate(&node, template)
+	}
+	entries.Content[index] = &node
+	return &node, nil
+}
+
+// annotateFingerprints adds a comment listing the SHA-256 fingerprints of
+// the certificates in its certChain above entry.
+func annotateFingerprints(entry *yaml.Node, fingerprints []string) {
+	entry.HeadComment = "SHA-256: " + strings.Join(fingerprints, "\nSHA-256: ")
+}
+
+// upsertIndex returns the index at which an entry identified by identity is
+// upserted into the named list of the sigstoreKeys mapping at keysPath in the
+// TrustRoot document root: the first existing entry with the same identity
+// that is not in claimed yet, or the end of the list. The returned index is
+// added to claimed, so authorities sharing a subject update one existing
+// entry each.
+func upsertIndex(root *yaml.Node, keysPath SigstoreKeysPath, section, identity string, claimed map[int]bool) (int, error) {
+	sigstoreKeys, err := keysPath.walk(root, false)
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []*yaml.Node
+	if node := mappingValue(sigstoreKeys, section); node != nil && node.Kind == yaml.SequenceNode {
+		entries = node.Content
+	}
+	for index, entry := range entries {
+		if claimed[index] {
+			continue
+		}
+		entryIdentity, err := entryIdentity(entry)
+		if err != nil {
+			return 0, fmt.Errorf("%s[%d]: %w", section, index, err)
+		}
+		if entryIdentity == identity {
+			claimed[index] = true
+			return index, nil
+		}
+	}
+	claimed[len(entries)] = true
+	return len(entries), nil
+}
+
+// entryIdentity returns what MergeUpsert matches a spec.sigstoreKeys
+// entry by: the subject of an authority or the baseURL of a transparency log.
+func entryIdentity(entry *yaml.Node) (string, error) {
+	var fields struct {
+		Subject struct {
+			Organization string `yaml:"organization"`
+			CommonName   string `yaml:"commonName"`
+		} `yaml:"subject"`
+		BaseURL string `yaml:"baseURL"`
+	}
+	if err := entry.Decode(&fields); err != nil {
+		return "", err
+	}
+	if fields.BaseURL != "" {
+		return fields.BaseURL, nil
+	}
+	return authorityIdentity(fields.Subject.Organization, fields.Subject.CommonName), nil
+}
+
+// authorityIdentity returns the identity of an authority with the given
+// subject, as compared by MergeUpsert.
+func authorityIdentity(organization, commonName string) string {
+	return organization + "\x00" + commonName
+}
+
+// clearSigstoreKeys empties every list of the sigstoreKeys mapping at
+// keysPath in the TrustRoot document root.
+func clearSigstoreKeys(root *yaml.Node, keysPath SigstoreKeysPath) error {
+	sigstoreKeys, err := keysPath.walk(root, false)
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(sigstoreKeys.Content); i += 2 {
+		if entries := sigstoreKeys.Content[i]; entries.Kind == yaml.SequenceNode {
+			entries.Content = nil
+		}
+	}
+	return nil
+}
+
+// SplitByAuthority returns two copies of the TrustRoot document root, whose
+// sigstoreKeys mapping is at keysPath: one without its timestampAuthorities,
+// and one with nothing but them. Their
+// metadata.name is suffixed with -certificate-authorities and
+// -timestamp-authorities so both can be applied side by side.
+func SplitByAuthority(root *yaml.Node, keysPath SigstoreKeysPath) (certificateAuthorities, timestampAuthorities *yaml.Node, err error) {
+	certificateAuthorities, timestampAuthorities = deepCopyNode(root), deepCopyNode(root)
+	for _, document := range []*yaml.Node{certificateAuthorities, timestampAuthorities} {
+		sigstoreKeys, err := keysPath.walk(document, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := 0; i+1 < len(sigstoreKeys.Content); i += 2 {
+			isTimestampAuthorities := sigstoreKeys.Content[i].Value == "timestampAuthorities"
+			if entries := sigstoreKeys.Content[i+1]; entries.Kind == yaml.SequenceNode && isTimestampAuthorities == (document == certificateAuthorities) {
+				entries.Content = nil
+			}
+		}
+	}
+	suffixName(certificateAuthorities, "-certificate-authorities")
+	suffixName(timestampAuthorities, "-timestamp-authorities")
+	return certificateAuthorities, timestampAuthorities, nil
+}
+
+// removeDuplicateAuthorities removes every certificate and timestamp
+// authority of the sigstoreKeys mapping at keysPath in the TrustRoot
+// document root that repeats an earlier authority of the same list, and
+// returns the entries removed. Authorities repeat each other when their
+// subject, certChain, trustRoot and uri all match, the chains compared by
+// the hash of their certificates' DER, whatever their encoding. Authorities
+// with an empty certChain are never removed.
+func removeDuplicateAuthorities(root *yaml.Node, keysPath SigstoreKeysPath) ([]*yaml.Node, error) {
+	sigstoreKeys, err := keysPath.walk(root, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []*yaml.Node
+	for _, section := range []string{"certificateAuthorities", "timestampAuthorities"} {
+		entries := mappingValue(sigstoreKeys, section)
+		if entries == nil || entries.Kind != yaml.SequenceNode {
+			continue
+		}
+		seen := map[[sha256.Size]byte]bool{}
+		kept := entries.Content[:0]
+		for index, entry := range entries.Content {
+			key, ok, err := authorityKey(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", section, index, err)
+			}
+			if ok && seen[key] {
+				debugf("Removing %s at index %d: duplicate authority", section, index)
+				removed = append(removed, entry)
+				continue
+			}
+			seen[key] = true
+			kept = append(kept, entry)
+		}
+		entries.Content = kept
+	}
+	return removed, nil
+}
+
+// authorityKey returns the SHA-256 of the subject, uri and the DER of every
+// certificate of the certChain and trustRoot of a spec.sigstoreKeys
+// authority, in chain order. It reports false when the certChain holds no
+// certificate.
+func authorityKey(entry *yaml.Node) ([sha256.Size]byte, bool, error) {
+	var fields struct {
+		Subject struct {
+			Organization string `yaml:"organization"`
+			CommonName   string `yaml:"commonName"`
+		} `yaml:"subject"`
+		CertChain string `yaml:"certChain"`
+		TrustRoot string `yaml:"trustRoot"`
+		URI       string `yaml:"uri"`
+	}
+	if err := entry.Decode(&fields); err != nil {
+		return [sha256.Size]byte{}, false, err
+	}
+
+	hash := sha256.New()
+	certificates := 0
+	for _, chain := range []struct {
+		name, value string
+	}{{"certChain", fields.CertChain}, {"trustRoot", fields.TrustRoot}} {
+		pemData, err := decodeCertChain(chain.value)
+		if err != nil {
+			return [sha256.Size]byte{}, false, fmt.Errorf("invalid %s: %w", chain.name, err)
+		}
+		for {
+			var block *pem.Block
+			block, pemData = pem.Decode(pemData)
+			if block == nil {
+				break
+			}
+			if chain.name == "certChain" {
+				certificates++
+			}
+			hash.Write(block.Bytes)
+		}
+		// Separate the chains so a certificate cannot move from one to the
+		// other unnoticed.
+		hash.Write([]byte{0})
+	}
+	for _, field := range []string{fields.Subject.Organization, fields.Subject.CommonName, fields.URI} {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum, certificates > 0, nil
+}
+
+// sortSigstoreKeys sorts every list of the sigstoreKeys mapping at keysPath
+// in the TrustRoot document root, so the same input always renders the same
+// bytes. Authorities are ordered by subject and then by the serial number of
+// the first certificate of their chain, transparency logs by baseURL and
+// logID.
+func sortSigstoreKeys(root *yaml.Node, keysPath SigstoreKeysPath) error {
+	sigstoreKeys, err := keysPath.walk(root, false)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i < len(sigstoreKeys.Content); i += 2 {
+		entries := sigstoreKeys.Content[i]
+		if entries.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		keys := make(map[*yaml.Node]string, len(entries.Content))
+		for _, entry := range entries.Content {
+			key, err := entrySortKey(entry)
+			if err != nil {
+				return fmt.Errorf("%s: %w", sigstoreKeys.Content[i-1].Value, err)
+			}
+			keys[entry] = key
+		}
+		sort.SliceStable(entries.Content, func(a, b int) bool {
+			return keys[entries.Content[a]] < keys[entries.Content[b]]
+		})
+	}
+	return nil
+}
+
+// entrySortKey returns the key a spec.sigstoreKeys entry is sorted by.
+func entrySortKey(entry *yaml.Node) (string, error) {
+	var fields struct {
+		Subject struct {
+			Organization string `yaml:"organization"`
+			CommonName   string `yaml:"commonName"`
+		} `yaml:"subject"`
+		CertChain string `yaml:"certChain"`
+		BaseURL   string `yaml:"baseURL"`
+		LogID     string `yaml:"logID"`
+	}
+	if err := entry.Decode(&fields); err != nil {
+		return "", err
+	}
+
+	if fields.CertChain == "" {
+		return strings.Join([]string{fields.BaseURL, fields.LogID}, "\x00"), nil
+	}
+
+	// Serial numbers are at most 20 octets, so zero-padding their hex form
+	// makes string order match numeric order.
+	var serial string
+	pemData, err := decodeCertChain(fields.CertChain)
+	if err != nil {
+		return "", fmt.Errorf("invalid certChain: %w", err)
+	}
+	if block, _ := pem.Decode(pemData); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			serial = fmt.Sprintf("%042x", cert.SerialNumber)
+		}
+	}
+	return strings.Join([]string{fields.Subject.Organization, fields.Subject.CommonName, serial}, "\x00"), nil
+}
+
+// DefaultSigstoreKeysPath is where policy-controller TrustRoots hold their
+// sigstoreKeys mapping.
+var DefaultSigstoreKeysPath = SigstoreKeysPath{"spec", "sigstoreKeys"}
+
+// SigstoreKeysPath is the sequence of mapping keys leading from the top of
+// a TrustRoot document to its sigstoreKeys mapping. It is a flag.Value taking
+// a dotted path such as spec.sigstoreKeys. The empty path stands for
+// DefaultSigstoreKeysPath.
+type SigstoreKeysPath []string
+
+func (p *SigstoreKeysPath) String() string {
+	return strings.Join(*p, ".")
+}
+
+func (p *SigstoreKeysPath) Set(value string) error {
+	keys := strings.Split(value, ".")
+	for _, key := range keys {
+		if key == "" {
+			return fmt.Errorf("invalid path %q, expected dot-separated keys such as spec.sigstoreKeys", value)
+		}
+	}
+	*p = keys
+	return nil
+}
+
+// walk returns the sigstoreKeys mapping at p in the TrustRoot document root.
+// With create, missing or null mappings along p are created.
+func (p SigstoreKeysPath) walk(root *yaml.Node, create bool) (*yaml.Node, error) {
+	if len(p) == 0 {
+		p = DefaultSigstoreKeysPath
+	}
+	node := root.Content[0]
+	for i, key := range p {
+		next := mappingValue(node, key)
+		switch {
+		case next == nil && create:
+			next = &yaml.Node{Kind: yaml.MappingNode}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, next)
+		case next != nil && next.Kind == yaml.ScalarNode && next.Tag == "!!null" && create:
+			*next = yaml.Node{Kind: yaml.MappingNode, HeadComment: next.HeadComment, LineComment: next.LineComment}
+		case next == nil:
+			return nil, fmt.Errorf("template is missing %s", strings.Join(p[:i+1], "."))
+		case next.Kind != yaml.MappingNode:
+			return nil, fmt.Errorf("%s of the template is not a mapping", strings.Join(p[:i+1], "."))
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// mappingValue returns the value node for key in the mapping node, or nil
+// when the key is absent.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
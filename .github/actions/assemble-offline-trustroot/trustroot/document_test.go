@@ -0,0 +1,53 @@
+package trustroot
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestEncodeCertChainRoundTrip(t *testing.T) {
+	var pemData []byte
+	for _, cert := range newTestChain(t, "example.com", "example") {
+		pemBytes, err := encodePEM(BlockTypeCertificate, cert.Raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pemData = append(pemData, pemBytes...)
+	}
+
+	for _, encoding := range []string{CertChainPEM, CertChainBase64} {
+		for indent := DefaultYAMLIndent; indent <= maxYAMLIndent; indent++ {
+			t.Run(fmt.Sprintf("%s indented by %d", encoding, indent), func(t *testing.T) {
+				var root yaml.Node
+				if err := root.Encode(map[string]interface{}{
+					"spec": map[string]interface{}{"certChain": encodeCertChain(pemData, encoding)},
+				}); err != nil {
+					t.Fatal(err)
+				}
+				out, err := marshalYAML(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&root}}, indent)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var decoded struct {
+					Spec struct {
+						CertChain string `yaml:"certChain"`
+					} `yaml:"spec"`
+				}
+				if err := yaml.Unmarshal(out, &decoded); err != nil {
+					t.Fatalf("failed to parse:\n%s\n%v", out, err)
+				}
+				got, err := decodeCertChain(decoded.Spec.CertChain)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, pemData) {
+					t.Errorf("certChain = %q, want %q, rendered as:\n%s", got, pemData, out)
+				}
+			})
+		}
+	}
+}
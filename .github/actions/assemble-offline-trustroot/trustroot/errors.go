@@ -0,0 +1,103 @@
+package trustroot
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// ErrorKind is the category of an Error.
+type ErrorKind int
+
+const (
+	// KindInput is a problem with the configuration or with reading the
+	// template or trusted roots.
+	KindInput ErrorKind = iota + 1
+	// KindValidation is input that could be read but failed to parse or
+	// validate.
+	KindValidation
+	// KindOutput is a failure to render the TrustRoot.
+	KindOutput
+	// KindStrict is a warning turned into an error by Config.Strict.
+	KindStrict
+)
+
+// Error is an error returned by the assembler along with its category.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func inputError(format string, args ...interface{}) error {
+	return &Error{Kind: KindInput, Err: fmt.Errorf(format, args...)}
+}
+
+func validationError(format string, args ...interface{}) error {
+	return &Error{Kind: KindValidation, Err: fmt.Errorf(format, args...)}
+}
+
+func outputError(format string, args ...interface{}) error {
+	return &Error{Kind: KindOutput, Err: fmt.Errorf(format, args...)}
+}
+
+func strictError(err error) error {
+	return &Error{Kind: KindStrict, Err: fmt.Errorf("strict mode: %w", err)}
+}
+
+// Errors describing why a certificate or public key was rejected, to be
+// matched with errors.Is.
+var (
+	ErrDecodeRawBytes   = errors.New("failed to decode rawBytes")
+	ErrNotDER           = errors.New("not a DER certificate")
+	ErrParseCertificate = errors.New("failed to parse certificate")
+	ErrParsePublicKey   = errors.New("failed to parse public key")
+	ErrUnsupportedKey   = errors.New("unsupported public key type")
+	ErrEncodePEM        = errors.New("failed to encode PEM")
+	ErrExpired          = errors.New("expired")
+	ErrNotYetValid      = errors.New("not yet valid")
+	ErrWeakCrypto       = errors.New("weak cryptography")
+	ErrChainTooLong     = errors.New("certChain too long")
+)
+
+// CertificateError is an error about a parsed certificate, identified by its
+// subject and serial number.
+type CertificateError struct {
+	Subject string
+	Serial  string
+	Err     error
+}
+
+func newCertificateError(cert *x509.Certificate, err error) *CertificateError {
+	return &CertificateError{Subject: cert.Subject.String(), Serial: cert.SerialNumber.String(), Err: err}
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("certificate %s (serial %s): %v", e.Subject, e.Serial, e.Err)
+}
+
+func (e *CertificateError) Unwrap() error { return e.Err }
+
+// warning is a logged warning kept as an error, wrapping the errors it was
+// formatted with so they can still be matched once Config.Strict returns it.
+type warning struct {
+	message string
+	causes  []error
+}
+
+func newWarning(format string, args ...interface{}) error {
+	w := &warning{message: fmt.Sprintf(format, args...)}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			w.causes = append(w.causes, err)
+		}
+	}
+	return w
+}
+
+func (w *warning) Error() string { return w.message }
+
+func (w *warning) Unwrap() []error { return w.causes }
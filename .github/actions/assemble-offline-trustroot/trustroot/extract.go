@@ -0,0 +1,49 @@
+package trustroot
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+)
+
+// ExtractCertChain returns the base64 certChain the TrustRoot would hold for
+// the certificate or timestamp authority, as named by authority, at index of
+// the single trusted root cfg describes. The certificates are converted as
+// for Build, but no template is read and nothing is selected or written.
+func ExtractCertChain(ctx context.Context, cfg Config, authority string, index int) (string, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.Validate(); err != nil {
+		return "", err
+	}
+	if authority != "certificateAuthorities" && authority != "timestampAuthorities" {
+		return "", inputError("unknown authority type %q, expected certificateAuthorities or timestampAuthorities", authority)
+	}
+
+	a := &assembler{
+		cfg:     cfg,
+		fetcher: &rootFetcher{fsys: cfg.FS, client: &http.Client{Timeout: cfg.HTTPTimeout}, retries: cfg.Retries, retryBackoff: cfg.RetryBackoff},
+	}
+	sources, err := a.readSources(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(sources) != 1 {
+		return "", inputError("extracting a certChain requires a single trusted root, got %d", len(sources))
+	}
+	source := sources[0]
+	canonicalizeTrustedRoot(source.trustedRoot, source.name)
+
+	authorities, _ := source.trustedRoot[authority].([]interface{})
+	if index < 0 || index >= len(authorities) {
+		return "", inputError("%s has no %s at index %d", source.name, authority, index)
+	}
+	p := a.prepareAuthority(authority, index, len(authorities), authorities[index])
+	p.log.Flush()
+	if cfg.Strict && p.warning != nil {
+		return "", strictError(p.warning)
+	}
+	if len(p.certs) == 0 {
+		return "", validationError("no certificate of %s at index %d could be converted", authority, index)
+	}
+	return base64.StdEncoding.EncodeToString(p.pemData), nil
+}
@@ -0,0 +1,170 @@
+package trustroot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rootFetcher reads trusted roots from files, stdin, URLs and TUF mirrors.
+type rootFetcher struct {
+	// fsys holds trusted roots and TUF roots given as local paths.
+	fsys fs.FS
+	// client fetches trusted roots given as URLs and TUF metadata.
+	client *http.Client
+	// retries and retryBackoff configure how often and after how long
+	// failed network fetches are retried.
+	retries      int
+	retryBackoff time.Duration
+}
+
+// contextTransport binds every request it sends to ctx, for clients that do
+// not take a context themselves.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// openTrustedRoot opens the trusted root at path, which is either "-" for
+// stdin, an http:// or https:// URL, or a path in f.fsys.
+func (f *rootFetcher) openTrustedRoot(ctx context.Context, path string) (io.ReadCloser, error) {
+	switch {
+	case path == "-":
+		return io.NopCloser(os.Stdin), nil
+	case strings.HasPrefix(path, "http://"), strings.HasPrefix(path, "https://"):
+		var resp *http.Response
+		err := f.withRetries(ctx, path, func() error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+			if err != nil {
+				return err
+			}
+			resp, err = f.client.Do(req)
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				return &httpStatusError{url: path, status: resp.Status, code: resp.StatusCode}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	default:
+		return f.fsys.Open(path)
+	}
+}
+
+// trustedRootSource is a decoded trusted root along with the path, URL or
+// mirror it was read from.
+type trustedRootSource struct {
+	name        string
+	trustedRoot map[string]interface{}
+}
+
+// trustedRootAliases maps names that some trusted_root.json variants use for
+// the authority lists to their canonical names: the proto field names, which
+// protojson also accepts, and the legacy "tsa".
+var trustedRootAliases = map[string]string{
+	"certificate_authorities": "certificateAuthorities",
+	"timestamp_authorities":   "timestampAuthorities",
+	"tsa":                     "timestampAuthorities",
+}
+
+// canonicalizeTrustedRoot renames the lists of trustedRoot found under one of
+// trustedRootAliases to their canonical name. An alias is ignored when the
+// canonical name is present too.
+func canonicalizeTrustedRoot(trustedRoot map[string]interface{}, name string) {
+	aliases := make([]string, 0, len(trustedRootAliases))
+	for alias := range trustedRootAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		value, ok := trustedRoot[alias]
+		if !ok {
+			continue
+		}
+		canonical := trustedRootAliases[alias]
+		if _, ok := trustedRoot[canonical]; ok {
+			warnf("Ignoring %s in %s: %s is present too", alias, name, canonical)
+			continue
+		}
+		infof("Reading %s in %s as %s", alias, name, canonical)
+		trustedRoot[canonical] = value
+		delete(trustedRoot, alias)
+	}
+}
+
+// supportedMediaTypes lists the trusted_root.json schema versions whose
+// layout the assembler knows how to read.
+var supportedMediaTypes = map[string]bool{
+	"application/vnd.dev.sigstore.trustedroot+json;version=0.1": true,
+	"application/vnd.dev.sigstore.trustedroot.v0.1+json":        true,
+	"application/vnd.dev.sigstore.trustedroot.v0.2+json":        true,
+}
+
+// checkMediaType returns the mediaType of trustedRoot, or an error when it is
+// missing or not one of supportedMediaTypes.
+func checkMediaType(trustedRoot map[string]interface{}) (string, error) {
+	mediaType, _ := trustedRoot["mediaType"].(string)
+	if mediaType == "" {
+		return "", validationError("missing mediaType, cannot tell the trusted_root.json schema version")
+	}
+	if !supportedMediaTypes[mediaType] {
+		return "", validationError("unsupported mediaType %q", mediaType)
+	}
+	return mediaType, nil
+}
+
+// readTrustedRoot opens and decodes the trusted root at path, decompressing
+// it first when it starts with the gzip magic number or path ends in ".gz".
+func (f *rootFetcher) readTrustedRoot(ctx context.Context, path string) (map[string]interface{}, error) {
+	input, err := f.openTrustedRoot(ctx, path)
+	if err != nil {
+		return nil, inputError("%w", err)
+	}
+	defer input.Close()
+
+	buffered := bufio.NewReader(input)
+	var reader io.Reader = buffered
+	magic, _ := buffered.Peek(len(gzipMagic))
+	compressed := bytes.Equal(magic, gzipMagic) || strings.HasSuffix(path, ".gz")
+	if compressed {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, validationError("failed to decompress: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	var trustedRoot map[string]interface{}
+	if err := json.NewDecoder(reader).Decode(&trustedRoot); err != nil {
+		if compressed && (errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) || errors.Is(err, io.ErrUnexpectedEOF)) {
+			return nil, validationError("failed to decompress: %w", err)
+		}
+		return nil, validationError("failed to decode: %w", err)
+	}
+	return trustedRoot, nil
+}
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
@@ -0,0 +1,46 @@
+package trustroot
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriterFS is a filesystem that files can also be written to.
+type WriterFS interface {
+	fs.FS
+	// WriteFile writes data to the file name, creating it or replacing it,
+	// with permissions perm.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// OSFS is the operating system's filesystem. Unlike os.DirFS, it takes paths
+// as the os package does: absolute, or relative to the working directory.
+var OSFS WriterFS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) { return os.Open(name) }
+
+// WriteFile writes data to a temporary file in the same directory as name and
+// renames it over name, so readers never observe a partial write.
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
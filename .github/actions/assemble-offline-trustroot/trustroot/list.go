@@ -0,0 +1,69 @@
+package trustroot
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+)
+
+// CertificateRow is one certificate of a selected authority, as listed by
+// ListCertificates.
+type CertificateRow struct {
+	Authority string
+	Index     int
+	CertIndex int
+	Cert      *x509.Certificate
+}
+
+// ListCertificates returns the certificates of the certificate and timestamp
+// authorities of the trusted roots cfg describes that cfg selects, in order.
+// The certificates are converted as for Build, but no template is read and
+// nothing is written.
+func ListCertificates(ctx context.Context, cfg Config) ([]CertificateRow, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	a := &assembler{
+		cfg:     cfg,
+		fetcher: &rootFetcher{fsys: cfg.FS, client: &http.Client{Timeout: cfg.HTTPTimeout}, retries: cfg.Retries, retryBackoff: cfg.RetryBackoff},
+	}
+	sources, err := a.readSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []CertificateRow
+	for _, source := range sources {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		canonicalizeTrustedRoot(source.trustedRoot, source.name)
+		for _, authority := range []string{"certificateAuthorities", "timestampAuthorities"} {
+			authorities, _ := source.trustedRoot[authority].([]interface{})
+			prepared := make([]*preparedAuthority, len(authorities))
+			a.forEach(len(authorities), func(index int) {
+				prepared[index] = a.prepareAuthority(authority, index, len(authorities), authorities[index])
+			})
+
+			for index, p := range prepared {
+				p.log.Flush()
+				var leaf *x509.Certificate
+				if len(p.certs) > 0 {
+					leaf = p.certs[0]
+				}
+				if !selectAuthority(index, leaf, cfg.IncludeAuthorities, cfg.ExcludeAuthorities) {
+					continue
+				}
+				if cfg.Strict && p.warning != nil {
+					return nil, strictError(p.warning)
+				}
+				for i, cert := range p.certs {
+					rows = append(rows, CertificateRow{Authority: authority, Index: index, CertIndex: p.certIndexes[i], Cert: cert})
+				}
+			}
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,24 @@
+package trustroot
+
+import "github.com/falcorocks/AutoTrustRoot/logging"
+
+var (
+	debugf = logging.Debugf
+	infof  = logging.Infof
+	warnf  = logging.Warnf
+)
+
+// logger is the destination of messages about one entry: a
+// logging.EntryLogger, a logging.Buffer, or the package logger.
+type logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// packageLogger logs through the package logger, without entry fields.
+type packageLogger struct{}
+
+func (packageLogger) Debugf(format string, args ...interface{}) { debugf(format, args...) }
+func (packageLogger) Infof(format string, args ...interface{})  { infof(format, args...) }
+func (packageLogger) Warnf(format string, args ...interface{})  { warnf(format, args...) }
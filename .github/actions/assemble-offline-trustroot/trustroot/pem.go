@@ -0,0 +1,72 @@
+package trustroot
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// pemSourceName names the trusted root source built from PEM files listed in
+// caPaths and tsaPaths.
+func pemSourceName(caPaths, tsaPaths []string) string {
+	return "pem:" + strings.Join(append(append([]string(nil), caPaths...), tsaPaths...), ",")
+}
+
+// readPEMSource reads the PEM files of caPaths and tsaPaths in fsys as the
+// certificate and timestamp authorities of a trusted root, each file holding
+// the chain of one authority. Every certificate must parse.
+func readPEMSource(fsys fs.FS, caPaths, tsaPaths []string) (trustedRootSource, error) {
+	trustedRoot := map[string]interface{}{}
+	for _, section := range []struct {
+		authority string
+		paths     []string
+	}{{"certificateAuthorities", caPaths}, {"timestampAuthorities", tsaPaths}} {
+		authority, paths := section.authority, section.paths
+		var authorities []interface{}
+		for _, path := range paths {
+			certificates, err := readPEMChain(fsys, path)
+			if err != nil {
+				return trustedRootSource{}, fmt.Errorf("%s: %w", path, err)
+			}
+			authorities = append(authorities, map[string]interface{}{
+				"certChain": map[string]interface{}{"certificates": certificates},
+			})
+		}
+		if authorities != nil {
+			trustedRoot[authority] = authorities
+		}
+	}
+	return trustedRootSource{name: pemSourceName(caPaths, tsaPaths), trustedRoot: trustedRoot}, nil
+}
+
+// readPEMChain returns the certificates of the PEM file at path in fsys as
+// the certificate entries of a trusted root certChain, in file order.
+func readPEMChain(fsys fs.FS, path string) ([]interface{}, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []interface{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != BlockTypeCertificate && block.Type != BlockTypeTrustedCertificate {
+			return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("certificate %d: %w: %w", len(certificates), ErrParseCertificate, err)
+		}
+		certificates = append(certificates, map[string]interface{}{"rawBytes": base64.StdEncoding.EncodeToString(block.Bytes)})
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	return certificates, nil
+}
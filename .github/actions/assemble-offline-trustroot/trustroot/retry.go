@@ -0,0 +1,65 @@
+package trustroot
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// httpStatusError reports an unexpected HTTP response status.
+type httpStatusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e *httpStatusError) Error() string {
+	return "fetching " + e.url + ": unexpected status " + e.status
+}
+
+// withRetries calls fetch until it succeeds, fails with an error that is not
+// worth retrying, or f.retries retries are used up. The delay before retry n
+// is f.retryBackoff doubled n-1 times, plus up to as much jitter. It gives up
+// early when ctx is done.
+func (f *rootFetcher) withRetries(ctx context.Context, what string, fetch func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fetch()
+		if err == nil || attempt > f.retries || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := f.retryBackoff << (attempt - 1)
+		if delay > 0 {
+			delay += rand.N(delay)
+		}
+		warnf("Attempt %d to fetch %s failed, retrying in %s: %v", attempt, what, delay.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isRetryable reports whether err is a network error or a 5xx response, as
+// opposed to a 4xx response or a failure to verify what was fetched.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= http.StatusInternalServerError
+	}
+	var tufErr *metadata.ErrDownloadHTTP
+	if errors.As(err, &tufErr) {
+		return tufErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
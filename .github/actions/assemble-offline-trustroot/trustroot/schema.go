@@ -0,0 +1,147 @@
+package trustroot
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TrustRoot resource identifiers expected by policy-controller.
+const (
+	trustRootAPIVersion = "policy.sigstore.dev/v1alpha1"
+	trustRootKind       = "TrustRoot"
+)
+
+// ValidateSchema checks the TrustRoot document root against the fields the
+// policy-controller TrustRoot CRD requires, and describes every violation
+// prefixed with its JSONPath-like location, e.g.
+// "$.spec.sigstoreKeys.tLogs[0].baseURL: missing".
+func ValidateSchema(root *yaml.Node) []string {
+	var v schemaViolations
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		v.add("$", "not a mapping")
+		return v
+	}
+	document := root.Content[0]
+
+	if apiVersion := v.scalar(document, "$", "apiVersion", true); apiVersion != nil && apiVersion.Value != trustRootAPIVersion {
+		v.add("$.apiVersion", fmt.Sprintf("is %q, expected %q", apiVersion.Value, trustRootAPIVersion))
+	}
+	if kind := v.scalar(document, "$", "kind", true); kind != nil && kind.Value != trustRootKind {
+		v.add("$.kind", fmt.Sprintf("is %q, expected %q", kind.Value, trustRootKind))
+	}
+	if metadata := v.mapping(document, "$", "metadata"); metadata != nil {
+		v.scalar(metadata, "$.metadata", "name", true)
+	}
+
+	spec := v.mapping(document, "$", "spec")
+	if spec == nil {
+		return v
+	}
+	sigstoreKeys := v.mapping(spec, "$.spec", "sigstoreKeys")
+	if sigstoreKeys == nil {
+		return v
+	}
+	for i := 0; i+1 < len(sigstoreKeys.Content); i += 2 {
+		section := sigstoreKeys.Content[i].Value
+		path := "$.spec.sigstoreKeys." + section
+		if !isSigstoreKeysSection(section) {
+			v.add(path, "unknown field")
+			continue
+		}
+		entries := sigstoreKeys.Content[i+1]
+		if entries.Kind != yaml.SequenceNode {
+			v.add(path, "not a list")
+			continue
+		}
+		for index, entry := range entries.Content {
+			entryPath := fmt.Sprintf("%s[%d]", path, index)
+			if entry.Kind != yaml.MappingNode {
+				v.add(entryPath, "not a mapping")
+				continue
+			}
+			switch section {
+			case "certificateAuthorities", "timestampAuthorities":
+				v.authority(entry, entryPath)
+			default:
+				v.transparencyLog(entry, entryPath)
+			}
+		}
+	}
+	return v
+}
+
+// schemaViolations collects the violations found by ValidateSchema.
+type schemaViolations []string
+
+func (v *schemaViolations) add(path, problem string) {
+	*v = append(*v, path+": "+problem)
+}
+
+// authority checks a certificateAuthorities or timestampAuthorities entry.
+func (v *schemaViolations) authority(entry *yaml.Node, path string) {
+	if subject := v.mapping(entry, path, "subject"); subject != nil {
+		v.scalar(subject, path+".subject", "organization", true)
+		v.scalar(subject, path+".subject", "commonName", true)
+	}
+	v.scalar(entry, path, "certChain", true)
+	v.scalar(entry, path, "uri", true)
+	if mappingValue(entry, "validFor") != nil {
+		if validFor := v.mapping(entry, path, "validFor"); validFor != nil {
+			for _, key := range []string{"start", "end"} {
+				if mappingValue(validFor, key) == nil {
+					continue
+				}
+				if value := v.scalar(validFor, path+".validFor", key, true); value != nil {
+					if _, err := time.Parse(time.RFC3339, value.Value); err != nil {
+						v.add(path+".validFor."+key, "not an RFC 3339 timestamp")
+					}
+				}
+			}
+		}
+	}
+}
+
+// transparencyLog checks a tLogs or ctLogs entry.
+func (v *schemaViolations) transparencyLog(entry *yaml.Node, path string) {
+	v.scalar(entry, path, "baseURL", true)
+	v.scalar(entry, path, "hashAlgorithm", true)
+	v.scalar(entry, path, "publicKey", true)
+	if mappingValue(entry, "logID") != nil {
+		v.scalar(entry, path, "logID", false)
+	}
+}
+
+// scalar returns the scalar value of key in mapping, recording a violation
+// when it is missing, not a scalar or, if required, empty.
+func (v *schemaViolations) scalar(mapping *yaml.Node, path, key string, required bool) *yaml.Node {
+	value := mappingValue(mapping, key)
+	switch {
+	case value == nil:
+		v.add(path+"."+key, "missing")
+		return nil
+	case value.Kind != yaml.ScalarNode || value.Tag == "!!null":
+		v.add(path+"."+key, "not a string")
+		return nil
+	case required && value.Value == "":
+		v.add(path+"."+key, "empty")
+		return nil
+	}
+	return value
+}
+
+// mapping returns the mapping value of key in mapping, recording a violation
+// when it is missing or not a mapping.
+func (v *schemaViolations) mapping(mapping *yaml.Node, path, key string) *yaml.Node {
+	value := mappingValue(mapping, key)
+	switch {
+	case value == nil:
+		v.add(path+"."+key, "missing")
+		return nil
+	case value.Kind != yaml.MappingNode:
+		v.add(path+"."+key, "not a mapping")
+		return nil
+	}
+	return value
+}
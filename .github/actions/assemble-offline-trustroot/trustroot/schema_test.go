@@ -0,0 +1,75 @@
+package trustroot
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateSchemaAuthorityURI(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		authority string
+		// uri is the uri of the authority, left out when nil.
+		uri  interface{}
+		want []string
+	}{
+		{
+			name:      "certificate authority with uri",
+			authority: "certificateAuthorities",
+			uri:       "https://fulcio.sigstore.dev",
+			want:      nil,
+		},
+		{
+			name:      "certificate authority without uri",
+			authority: "certificateAuthorities",
+			want:      []string{"$.spec.sigstoreKeys.certificateAuthorities[0].uri: missing"},
+		},
+		{
+			name:      "certificate authority with empty uri",
+			authority: "certificateAuthorities",
+			uri:       "",
+			want:      []string{"$.spec.sigstoreKeys.certificateAuthorities[0].uri: empty"},
+		},
+		{
+			name:      "timestamp authority without uri",
+			authority: "timestampAuthorities",
+			want:      []string{"$.spec.sigstoreKeys.timestampAuthorities[0].uri: missing"},
+		},
+		{
+			name:      "timestamp authority with empty uri",
+			authority: "timestampAuthorities",
+			uri:       "",
+			want:      []string{"$.spec.sigstoreKeys.timestampAuthorities[0].uri: empty"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			entry := map[string]interface{}{
+				"subject":   map[string]string{"organization": "sigstore.dev", "commonName": "sigstore"},
+				"certChain": "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t",
+			}
+			if test.uri != nil {
+				entry["uri"] = test.uri
+			}
+			document := map[string]interface{}{
+				"apiVersion": trustRootAPIVersion,
+				"kind":       trustRootKind,
+				"metadata":   map[string]string{"name": "sigstore"},
+				"spec": map[string]interface{}{
+					"sigstoreKeys": map[string]interface{}{
+						test.authority: []interface{}{entry},
+					},
+				},
+			}
+
+			var root yaml.Node
+			if err := root.Encode(document); err != nil {
+				t.Fatal(err)
+			}
+			if got := ValidateSchema(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&root}}); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ValidateSchema() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package trustroot
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// URIMap holds per-authority URIs, keyed by the authority's index in the
+// trusted root or by its subject's common name. It is a flag.Value taking
+// comma-separated index=uri or commonName=uri pairs.
+type URIMap struct {
+	values       []string
+	byIndex      map[int]string
+	byCommonName map[string]string
+}
+
+func (m *URIMap) String() string {
+	return strings.Join(m.values, ",")
+}
+
+func (m *URIMap) Set(value string) error {
+	values := splitList(value)
+	if m.byIndex == nil {
+		m.byIndex = map[int]string{}
+		m.byCommonName = map[string]string{}
+	}
+	for _, v := range values {
+		key, uri, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid pair %q, expected index=uri or commonName=uri", v)
+		}
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			return fmt.Errorf("invalid URI for %q: %w", key, err)
+		}
+		if parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("invalid URI for %q: %q is not an https URL", key, uri)
+		}
+		if index, err := strconv.Atoi(key); err == nil {
+			m.byIndex[index] = uri
+		} else {
+			m.byCommonName[key] = uri
+		}
+	}
+	m.values = append(m.values, values...)
+	return nil
+}
+
+// lookup returns the URI of the authority at index with the given common
+// name. A match by index takes precedence over one by common name.
+func (m *URIMap) lookup(index int, commonName string) (string, bool) {
+	if uri, ok := m.byIndex[index]; ok {
+		return uri, true
+	}
+	uri, ok := m.byCommonName[commonName]
+	return uri, ok
+}
+
+// AuthoritySelector matches authorities by their index in the trusted root,
+// or by a substring of their certificate's subject. It is a flag.Value taking
+// comma-separated indices and substrings.
+type AuthoritySelector struct {
+	values     []string
+	indices    map[int]bool
+	substrings []string
+}
+
+func (s *AuthoritySelector) String() string {
+	return strings.Join(s.values, ",")
+}
+
+func (s *AuthoritySelector) Set(value string) error {
+	values := splitList(value)
+	if s.indices == nil {
+		s.indices = map[int]bool{}
+	}
+	for _, v := range values {
+		if index, err := strconv.Atoi(v); err == nil {
+			s.indices[index] = true
+		} else {
+			s.substrings = append(s.substrings, v)
+		}
+	}
+	s.values = append(s.values, values...)
+	return nil
+}
+
+// empty reports whether the selector matches nothing because no value was set.
+func (s *AuthoritySelector) empty() bool {
+	return len(s.values) == 0
+}
+
+// matches reports whether the authority at index, whose chain starts with
+// leaf, is selected. leaf may be nil, in which case only indices match.
+func (s *AuthoritySelector) matches(index int, leaf *x509.Certificate) bool {
+	if s.indices[index] {
+		return true
+	}
+	if leaf == nil {
+		return false
+	}
+	subject := leaf.Subject.String()
+	for _, substring := range s.substrings {
+		if strings.Contains(subject, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectAuthority reports whether the authority at index should be written
+// given the include and exclude selectors. With no include selector every
+// authority is included, and exclude always wins.
+func selectAuthority(index int, leaf *x509.Certificate, include, exclude AuthoritySelector) bool {
+	if exclude.matches(index, leaf) {
+		return false
+	}
+	return include.empty() || include.matches(index, leaf)
+}
+
+// Values of Config.UnapprovedIssuerAction.
+const (
+	IssuerActionSkip = "skip"
+	IssuerActionFail = "fail"
+)
+
+// IssuerAllowlist holds the issuers authorities may be issued by, matched
+// exactly against the common name or an organization of an issuer. It is a
+// flag.Value taking comma-separated names.
+type IssuerAllowlist struct {
+	values []string
+}
+
+func (l *IssuerAllowlist) String() string {
+	return strings.Join(l.values, ",")
+}
+
+func (l *IssuerAllowlist) Set(value string) error {
+	l.values = append(l.values, splitList(value)...)
+	return nil
+}
+
+// allows reports whether cert is issued by an issuer on the list. An empty
+// list allows every issuer.
+func (l *IssuerAllowlist) allows(cert *x509.Certificate) bool {
+	if len(l.values) == 0 {
+		return true
+	}
+	for _, value := range l.values {
+		if cert.Issuer.CommonName == value || slices.Contains(cert.Issuer.Organization, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated value, dropping empty elements.
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
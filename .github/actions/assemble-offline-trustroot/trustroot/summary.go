@@ -0,0 +1,144 @@
+package trustroot
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"time"
+)
+
+// Summary describes what a run assembled.
+type Summary struct {
+	Sources []string `json:"sources"`
+	// Count is the number of authorities written, CertificateAuthorities
+	// and TimestampAuthorities the number of each type.
+	Count                  int `json:"count"`
+	CertificateAuthorities int `json:"certificateAuthorities"`
+	TimestampAuthorities   int `json:"timestampAuthorities"`
+	// SkippedCertificates is the number of certificates listed in the
+	// trusted roots that were not written, on their own or with their
+	// authority.
+	SkippedCertificates int `json:"skippedCertificates"`
+	// DuplicatesRemoved is the number of authorities removed from the
+	// assembled TrustRoot for repeating the chain of an earlier one.
+	DuplicatesRemoved int                 `json:"duplicatesRemoved"`
+	Authorities       []*AuthoritySummary `json:"authorities"`
+}
+
+// Values of AuthoritySummary.Status.
+const (
+	// StatusComplete is an authority written with every certificate the
+	// trusted root lists for it.
+	StatusComplete = "complete"
+	// StatusPartial is an authority written without some of its
+	// certificates, which could not be converted.
+	StatusPartial = "partial"
+	// StatusFailed is an authority that could not be written.
+	StatusFailed = "failed"
+	// StatusSkipped is an authority left out on purpose: not selected,
+	// issued by an unapproved issuer, with a certChain longer than
+	// Config.MaxChainLength, or repeating the certChain of an authority
+	// already written.
+	StatusSkipped = "skipped"
+	// StatusDuplicate is an authority written and then removed from the
+	// assembled TrustRoot for repeating an earlier one.
+	StatusDuplicate = "duplicate"
+)
+
+// AuthoritySummary describes one certificate or timestamp authority of a
+// trusted root and whether it was written to the TrustRoot.
+type AuthoritySummary struct {
+	Type         string   `json:"type"`
+	Source       string   `json:"source"`
+	Index        int      `json:"index"`
+	Subject      string   `json:"subject,omitempty"`
+	Certificates int      `json:"certificates"`
+	Listed       int      `json:"listed"`
+	NotAfter     []string `json:"notAfter,omitempty"`
+	Skipped      bool     `json:"skipped"`
+	Status       string   `json:"status"`
+}
+
+// addAuthority records the authority at index of source, which lists listed
+// certificates. It and its certificates are reported as failed until
+// markSkipped or markWritten is called.
+func (s *Summary) addAuthority(authority, source string, index, listed int) *AuthoritySummary {
+	entry := &AuthoritySummary{Type: authority, Source: source, Index: index, Listed: listed, Skipped: true, Status: StatusFailed}
+	s.Authorities = append(s.Authorities, entry)
+	s.SkippedCertificates += listed
+	return entry
+}
+
+// setCertificates records the certificates of the authority's chain, leaf
+// first.
+func (a *AuthoritySummary) setCertificates(certs []*x509.Certificate) {
+	a.Certificates = len(certs)
+	a.NotAfter = nil
+	for _, cert := range certs {
+		a.NotAfter = append(a.NotAfter, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if len(certs) > 0 {
+		a.Subject = certs[0].Subject.String()
+	}
+}
+
+// markSkipped records that the authority was left out on purpose.
+func (a *AuthoritySummary) markSkipped() {
+	a.Status = StatusSkipped
+}
+
+// markWritten records that the authority was written to the TrustRoot.
+func (s *Summary) markWritten(entry *AuthoritySummary) {
+	entry.Skipped = false
+	entry.Status = StatusComplete
+	if entry.Certificates < entry.Listed {
+		entry.Status = StatusPartial
+	}
+	s.Count++
+	if entry.Type == "timestampAuthorities" {
+		s.TimestampAuthorities++
+	} else {
+		s.CertificateAuthorities++
+	}
+	s.SkippedCertificates -= entry.Certificates
+}
+
+// markDuplicate records that the authority, written earlier, was removed
+// from the TrustRoot as a duplicate.
+func (s *Summary) markDuplicate(entry *AuthoritySummary) {
+	entry.Skipped = true
+	entry.Status = StatusDuplicate
+	s.Count--
+	if entry.Type == "timestampAuthorities" {
+		s.TimestampAuthorities--
+	} else {
+		s.CertificateAuthorities--
+	}
+	s.SkippedCertificates += entry.Certificates
+	s.DuplicatesRemoved++
+}
+
+// Outcomes returns the number of authorities written completely, written
+// partially and failed. Authorities skipped on purpose or removed as
+// duplicates are not counted.
+func (s *Summary) Outcomes() (complete, partial, failed int) {
+	for _, authority := range s.Authorities {
+		switch authority.Status {
+		case StatusComplete:
+			complete++
+		case StatusPartial:
+			partial++
+		case StatusFailed:
+			failed++
+		}
+	}
+	return complete, partial, failed
+}
+
+// Marshal renders the summary as indented JSON.
+func (s *Summary) Marshal() ([]byte, error) {
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
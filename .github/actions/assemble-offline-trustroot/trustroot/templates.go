@@ -0,0 +1,160 @@
+package trustroot
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTemplatePath is the TrustRoot template used when a template value
+// only names entry templates.
+const DefaultTemplatePath = "trustroot.template.yaml"
+
+// sigstoreKeysSections lists the spec.sigstoreKeys lists of a TrustRoot.
+var sigstoreKeysSections = []string{"certificateAuthorities", "timestampAuthorities", "tLogs", "ctLogs"}
+
+// ParseTemplatePaths splits a --template-filepath value into the path of the
+// TrustRoot template and the paths of entry templates by list. The value is a
+// comma-separated list of a single TrustRoot template path and section=path
+// pairs; the TrustRoot template defaults to DefaultTemplatePath when only
+// pairs are given.
+func ParseTemplatePaths(value string) (string, map[string]string, error) {
+	base := ""
+	entries := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		section, path, ok := strings.Cut(part, "=")
+		if !ok {
+			if base != "" {
+				return "", nil, fmt.Errorf("more than one TrustRoot template: %s and %s", base, part)
+			}
+			base = part
+			continue
+		}
+		if !isSigstoreKeysSection(section) {
+			return "", nil, fmt.Errorf("unknown list %q, expected one of: %s", section, strings.Join(sigstoreKeysSections, ", "))
+		}
+		entries[section] = path
+	}
+	if base == "" {
+		base = DefaultTemplatePath
+	}
+	return base, entries, nil
+}
+
+func isSigstoreKeysSection(section string) bool {
+	for _, known := range sigstoreKeysSections {
+		if section == known {
+			return true
+		}
+	}
+	return false
+}
+
+// TemplateVars holds the values of the ${KEY} placeholders substituted into
+// templates before they are parsed. It is a flag.Value taking a key=value
+// pair, and may be set repeatedly.
+type TemplateVars struct {
+	values []string
+	vars   map[string]string
+}
+
+// templateVarName matches the keys of template variables.
+var templateVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// templatePlaceholder matches a ${KEY} placeholder, capturing KEY.
+var templatePlaceholder = regexp.MustCompile(`\$\{([^{}]*)\}`)
+
+func (v *TemplateVars) String() string {
+	return strings.Join(v.values, ",")
+}
+
+func (v *TemplateVars) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || !templateVarName.MatchString(key) {
+		return fmt.Errorf("invalid pair %q, expected key=value with a key of letters, digits and underscores", value)
+	}
+	if v.vars == nil {
+		v.vars = map[string]string{}
+	}
+	v.vars[key] = val
+	v.values = append(v.values, value)
+	return nil
+}
+
+// expand replaces every placeholder of data whose key is set with its value.
+// The text is substituted as is, before any YAML parsing. With strict, a
+// placeholder left unsubstituted is an error.
+func (v TemplateVars) expand(data []byte, strict bool) ([]byte, error) {
+	var missing []string
+	out := templatePlaceholder.ReplaceAllFunc(data, func(placeholder []byte) []byte {
+		key := string(templatePlaceholder.FindSubmatch(placeholder)[1])
+		if value, ok := v.vars[key]; ok {
+			return []byte(value)
+		}
+		missing = append(missing, key)
+		return placeholder
+	})
+	if strict && len(missing) > 0 {
+		return nil, fmt.Errorf("unsubstituted template variables: %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// loadTemplate reads the TrustRoot document at path in fsys, substitutes vars
+// into it and parses it.
+func loadTemplate(fsys fs.FS, path string, vars TemplateVars, strict bool) (*yaml.Node, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = vars.expand(data, strict); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return parseYAML(data, path)
+}
+
+// loadEntryTemplates loads the entry template of each list in paths from
+// fsys, each a YAML mapping, substituting vars into them.
+func loadEntryTemplates(fsys fs.FS, paths map[string]string, vars TemplateVars, strict bool) (map[string]*yaml.Node, error) {
+	templates := map[string]*yaml.Node{}
+	for section, path := range paths {
+		root, err := loadTemplate(fsys, path, vars, strict)
+		if err != nil {
+			return nil, fmt.Errorf("%s template: %w", section, err)
+		}
+		templates[section] = root.Content[0]
+	}
+	return templates, nil
+}
+
+// mergeEntryTemplate adds the keys of the mapping template that entry does
+// not set to entry, so values written by the assembler take precedence.
+func mergeEntryTemplate(entry, template *yaml.Node) {
+	for i := 0; i+1 < len(template.Content); i += 2 {
+		if mappingValue(entry, template.Content[i].Value) != nil {
+			continue
+		}
+		entry.Content = append(entry.Content, deepCopyNode(template.Content[i]), deepCopyNode(template.Content[i+1]))
+	}
+}
+
+// deepCopyNode returns a copy of node that shares no nodes with it, so a
+// template merged into several entries renders independently in each.
+func deepCopyNode(node *yaml.Node) *yaml.Node {
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = deepCopyNode(child)
+	}
+	if node.Alias != nil {
+		copied.Alias = deepCopyNode(node.Alias)
+	}
+	return &copied
+}
@@ -0,0 +1,67 @@
+package trustroot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+
+	"github.com/sigstore/sigstore-go/pkg/tuf"
+	"github.com/theupdateframework/go-tuf/v2/metadata/fetcher"
+)
+
+// tufTrustedRootTarget is the TUF target holding the Sigstore trusted root.
+const tufTrustedRootTarget = "trusted_root.json"
+
+// fetchTUFTrustedRoot downloads trusted_root.json from the TUF repository at
+// mirror and decodes it. The TUF metadata is verified starting from the
+// root.json at rootPath, or from the embedded Sigstore public-good root when
+// rootPath is empty. Requests share f.client and its timeout, are aborted
+// when ctx is done, and nothing is cached on disk. Network failures are
+// retried with withRetries.
+func (f *rootFetcher) fetchTUFTrustedRoot(ctx context.Context, mirror, rootPath string) (map[string]interface{}, error) {
+	client := *f.client
+	client.Transport = contextTransport{ctx: ctx, base: http.DefaultTransport}
+	tufFetcher := fetcher.NewDefaultFetcher()
+	tufFetcher.SetHTTPClient(&client)
+
+	opts := tuf.DefaultOptions().
+		WithRepositoryBaseURL(mirror).
+		WithDisableLocalCache().
+		WithFetcher(tufFetcher)
+	if rootPath != "" {
+		root, err := fs.ReadFile(f.fsys, rootPath)
+		if err != nil {
+			return nil, inputError("failed to read TUF root: %w", err)
+		}
+		opts = opts.WithRoot(root)
+	}
+
+	var data []byte
+	err := f.withRetries(ctx, tufSourceName(mirror), func() error {
+		tufClient, err := tuf.New(opts)
+		if err != nil {
+			return inputError("failed to initialize TUF client for %s: %w", mirror, err)
+		}
+		data, err = tufClient.GetTarget(tufTrustedRootTarget)
+		if err != nil {
+			return inputError("failed to fetch %s from %s: %w", tufTrustedRootTarget, mirror, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var trustedRoot map[string]interface{}
+	if err := json.Unmarshal(data, &trustedRoot); err != nil {
+		return nil, validationError("failed to decode %s from %s: %w", tufTrustedRootTarget, mirror, err)
+	}
+	return trustedRoot, nil
+}
+
+// tufSourceName names a TUF mirror in logs and errors.
+func tufSourceName(mirror string) string {
+	return fmt.Sprintf("tuf:%s", mirror)
+}
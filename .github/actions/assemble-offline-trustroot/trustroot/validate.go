@@ -0,0 +1,139 @@
+package trustroot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DiffSigstoreKeys compares the lists of the sigstoreKeys mappings at
+// keysPath of the TrustRoot that would be generated (expected) and an
+// existing one (actual), and describes every difference. Authorities are
+// compared index by index by the SHA-256 fingerprints of the certificates in
+// their chain, transparency logs by their public key.
+func DiffSigstoreKeys(expected, actual *yaml.Node, keysPath SigstoreKeysPath) ([]string, error) {
+	expectedKeys, err := keysPath.walk(expected, false)
+	if err != nil {
+		return nil, err
+	}
+	actualKeys, err := keysPath.walk(actual, false)
+	if err != nil {
+		return nil, fmt.Errorf("existing TrustRoot: %w", err)
+	}
+
+	var diffs []string
+	for _, section := range sigstoreKeysSections {
+		expectedEntries := sectionEntries(expectedKeys, section)
+		actualEntries := sectionEntries(actualKeys, section)
+
+		for index := 0; index < len(expectedEntries) || index < len(actualEntries); index++ {
+			switch {
+			case index >= len(actualEntries):
+				diffs = append(diffs, fmt.Sprintf("%s[%d]: added", section, index))
+				continue
+			case index >= len(expectedEntries):
+				diffs = append(diffs, fmt.Sprintf("%s[%d]: removed", section, index))
+				continue
+			}
+
+			expectedFingerprints, err := entryFingerprints(expectedEntries[index])
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", section, index, err)
+			}
+			actualFingerprints, err := entryFingerprints(actualEntries[index])
+			if err != nil {
+				return nil, fmt.Errorf("existing TrustRoot %s[%d]: %w", section, index, err)
+			}
+			if diff := diffFingerprints(expectedFingerprints, actualFingerprints); diff != "" {
+				diffs = append(diffs, fmt.Sprintf("%s[%d]: %s", section, index, diff))
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// sectionEntries returns the entries of the named spec.sigstoreKeys list, or
+// nil when it is absent or not a list.
+func sectionEntries(sigstoreKeys *yaml.Node, section string) []*yaml.Node {
+	entries := mappingValue(sigstoreKeys, section)
+	if entries == nil || entries.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return entries.Content
+}
+
+// entryFingerprints returns the hex SHA-256 fingerprints of the PEM blocks in
+// the certChain or publicKey of a spec.sigstoreKeys entry.
+func entryFingerprints(entry *yaml.Node) ([]string, error) {
+	var fields struct {
+		CertChain string `yaml:"certChain"`
+		PublicKey string `yaml:"publicKey"`
+	}
+	if err := entry.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	encoded := fields.CertChain
+	if encoded == "" {
+		encoded = fields.PublicKey
+	}
+	pemData, err := decodeCertChain(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+
+	var fingerprints []string
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+		sum := sha256.Sum256(block.Bytes)
+		fingerprints = append(fingerprints, hex.EncodeToString(sum[:]))
+	}
+	return fingerprints, nil
+}
+
+// diffFingerprints describes how the expected fingerprints differ from the
+// actual ones, or returns "" when both hold the same certificates or keys.
+func diffFingerprints(expected, actual []string) string {
+	added := missingFrom(expected, actual)
+	removed := missingFrom(actual, expected)
+	switch {
+	case len(added) > 0 && len(removed) > 0:
+		return fmt.Sprintf("rotated (%d added, %d removed)", len(added), len(removed))
+	case len(added) > 0:
+		return fmt.Sprintf("%d added", len(added))
+	case len(removed) > 0:
+		return fmt.Sprintf("%d removed", len(removed))
+	}
+	if len(expected) != len(actual) {
+		return "duplicates changed"
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			return "reordered"
+		}
+	}
+	return ""
+}
+
+// missingFrom returns the values of a that are not in b.
+func missingFrom(a, b []string) []string {
+	present := make(map[string]bool, len(b))
+	for _, value := range b {
+		present[value] = true
+	}
+
+	var missing []string
+	for _, value := range a {
+		if !present[value] {
+			missing = append(missing, value)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long --watch waits for writes to the watched files
+// to settle before regenerating the TrustRoot.
+const watchDebounce = 250 * time.Millisecond
+
+// watchedInputs returns the local files among the trusted roots, templates
+// and PEM files given, which --watch regenerates the TrustRoot on changes of.
+// Trusted roots read over HTTP are re-read on every regeneration but not
+// watched; stdin cannot be re-read at all.
+func watchedInputs(trustedRootPaths []string, templatePath string, entryTemplatePaths map[string]string, pemPaths ...[]string) ([]string, error) {
+	var paths []string
+	for _, path := range trustedRootPaths {
+		switch {
+		case path == "-":
+			return nil, fmt.Errorf("cannot watch a trusted root read from stdin")
+		case strings.HasPrefix(path, "http://"), strings.HasPrefix(path, "https://"):
+			continue
+		}
+		paths = append(paths, path)
+	}
+	if templatePath != embeddedTemplatePath {
+		paths = append(paths, templatePath)
+	}
+	for _, path := range entryTemplatePaths {
+		paths = append(paths, path)
+	}
+	for _, pemPaths := range pemPaths {
+		paths = append(paths, pemPaths...)
+	}
+	return paths, nil
+}
+
+// watchInputs calls generate once and then again whenever any of paths
+// changes, until ctx is canceled. Changes within watchDebounce of each other
+// trigger a single call. Errors of generate are logged rather than returned.
+func watchInputs(ctx context.Context, paths []string, generate func(ctx context.Context) error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return inputError("failed to start watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	// Editors often replace files instead of writing them, so the
+	// directories are watched and their events filtered by file name.
+	watched := map[string]bool{}
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return inputError("failed to resolve %s: %w", path, err)
+		}
+		if watched[path] {
+			continue
+		}
+		watched[path] = true
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			return inputError("failed to watch %s: %w", path, err)
+		}
+	}
+
+	regenerate := func(ctx context.Context) {
+		if err := generate(ctx); err != nil {
+			if ctx.Err() == nil {
+				errorf("%v", err)
+			}
+			return
+		}
+		infof("Regenerated the TrustRoot at %s", time.Now().Format(time.RFC3339))
+	}
+	regenerate(ctx)
+	infof("Watching %d file(s) for changes", len(watched))
+
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			infof("Stopped watching")
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !watched[filepath.Clean(event.Name)] || event.Op == fsnotify.Chmod {
+				continue
+			}
+			debugf("%s: %s", event.Name, event.Op)
+			debounce.Reset(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			warnf("Watcher error: %v", err)
+		case <-debounce.C:
+			regenerate(ctx)
+		}
+	}
+}